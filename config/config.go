@@ -3,17 +3,19 @@ package config
 import (
 	"log"
 	"os"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
 type SreaderConfig struct {
-	URLs []*string
+	URLs []*string // Subscribed to on startup unless already subscribed or unsubscribed
 
 	// Paths
-	DBFile  string
-	LogFile string
-	TmpDir  string
+	DBFile     string
+	LogFile    string
+	CookieFile string
+	StatusFile string // Written by "sreader daemon" for the UI to read
 
 	// Colors
 	BG              string
@@ -28,27 +30,97 @@ type SreaderConfig struct {
 	SelectedDescBG  string
 
 	// Keys
-	UpKey      string
-	DownKey    string
-	LeftKey    string
-	RightKey   string
-	QuitKey    string
-	SyncKey    string
-	BrowserKey string
-	PlayerKey  string
-	FilterKey  string
+	UpKey        string
+	DownKey      string
+	LeftKey      string
+	RightKey     string
+	QuitKey      string
+	SyncKey      string
+	ForceSyncKey string
+	BrowserKey   string
+	PlayerKey    string
+	FilterKey    string
+	SearchKey    string
+	StarKey      string
+	ReadKey      string
+	ReadAllKey   string
+	DiffKey      string
+	AddKey       string
+	RemoveKey    string
+	RenameKey    string
+	PauseKey     string
+	InfoKey      string
 
 	// External applications
 	Player  string
 	Browser string
+
+	// Retention
+	MaxEntries int  // Entries kept per feed, 0 to keep all
+	MaxAgeDays int  // Days to keep read entries, 0 to keep forever
+	Vacuum     bool // Compact the database after pruning
+
+	// Mark entries unread again when the feed changes their text
+	UnreadOnEdit bool
+
+	// Wait for a sync running in another process instead of skipping
+	WaitForSync bool
+
+	// Fetching
+	Timeout        int     // Seconds to wait for a feed to download
+	ConnectTimeout int     // Seconds to wait for a connection to a server
+	Retries        int     // Times to retry a feed after a timeout or server error
+	RetryDelay     int     // Seconds before the first retry, doubled for each one after it
+	Workers        int     // Feeds fetched at the same time, 0 for no limit
+	HostWorkers    int     // Feeds fetched at the same time from one host, 0 for no limit
+	HostRate       float64 // Requests started per second to one host, 0 for no limit
+	MaxFeedSize    int     // Largest feed downloaded, in megabytes, 0 for no limit
+	Interval       int     // Minutes between syncs of a feed, 0 to sync it every time
+	MaxInterval    int     // Longest wait between syncs of a feed that rarely changes, in minutes
+	UserAgent      string  // User-Agent header sent with requests
+	Cookies        bool    // Keep cookies set by feeds' servers and send them back
+	Proxy          string  // Proxy URL (http, https or socks5), "direct" for none, "" to use the environment
+	CAFile         string  // PEM bundle of certificate authorities to trust besides the system's
+	ClientCert     string  // PEM client certificate for TLS
+	ClientKey      string  // PEM key of ClientCert, if not in the same file
+
+	// Per-feed settings, keyed by feed URL
+	Feeds map[string]*FeedConfig
+}
+
+// Settings for a single feed. Unset fields fall back to the global settings.
+type FeedConfig struct {
+	MaxEntries   *int
+	MaxAgeDays   *int
+	UnreadOnEdit *bool
+	Interval     *int
+
+	// Requests. Secrets can be given as commands printing them instead, so
+	// that they need not be stored in the configuration file.
+	UserAgent       string
+	Username        string // Enables HTTP basic authentication
+	Password        string
+	PasswordCommand string
+	Token           string // Sent as a bearer token
+	TokenCommand    string
+	Headers         map[string]string // Extra request headers
+	HeaderCommands  map[string]string // Extra request headers, by the commands printing their values
+	Cookies         *bool
+
+	// Connection
+	Proxy      string
+	CAFile     string
+	ClientCert string
+	ClientKey  string
 }
 
 const (
 	// Default paths
-	DefaultConfFile string = "~/.config/sreader/config.toml"
-	defaultDBFile   string = "~/.local/share/sreader/sreader.db"
-	defaultLogFile  string = "~/.local/share/sreader/sreader.log"
-	defaultTmpDir   string = "~/.local/share/sreader"
+	DefaultConfFile   string = "~/.config/sreader/config.toml"
+	defaultDBFile     string = "~/.local/share/sreader/sreader.db"
+	defaultLogFile    string = "~/.local/share/sreader/sreader.log"
+	defaultCookieFile string = "~/.local/share/sreader/cookies.json"
+	defaultStatusFile string = "~/.local/share/sreader/daemon.json"
 
 	// Default colors
 	defaultBG              string = "#000000"
@@ -63,15 +135,39 @@ const (
 	defaultSelectedDescBG  string = "#7FB685"
 
 	// Default keys
-	defaultUpKey      string = "k"
-	defaultDownKey    string = "j"
-	defaultLeftKey    string = "h"
-	defaultRightKey   string = "l"
-	defaultQuitKey    string = "q"
-	defaultSyncKey    string = "r"
-	defaultBrowserKey string = "o"
-	defaultPlayerKey  string = "v"
-	defaultFilterKey  string = "/"
+	defaultUpKey        string = "k"
+	defaultDownKey      string = "j"
+	defaultLeftKey      string = "h"
+	defaultRightKey     string = "l"
+	defaultQuitKey      string = "q"
+	defaultSyncKey      string = "r"
+	defaultForceSyncKey string = "R"
+	defaultBrowserKey   string = "o"
+	defaultPlayerKey    string = "v"
+	defaultFilterKey    string = "/"
+	defaultSearchKey    string = "s"
+	defaultStarKey      string = "*"
+	defaultReadKey      string = "m"
+	defaultReadAllKey   string = "M"
+	defaultDiffKey      string = "d"
+	defaultAddKey       string = "a"
+	defaultRemoveKey    string = "x"
+	defaultRenameKey    string = "e"
+	defaultPauseKey     string = "p"
+	defaultInfoKey      string = "i"
+
+	// Default fetch settings
+	defaultTimeout        int     = 60
+	defaultConnectTimeout int     = 15
+	defaultRetries        int     = 2
+	defaultRetryDelay     int     = 2
+	defaultWorkers        int     = 16
+	defaultHostWorkers    int     = 2
+	defaultHostRate       float64 = 2
+	defaultMaxFeedSize    int     = 20
+	defaultInterval       int     = 30
+	defaultMaxInterval    int     = 24 * 60
+	defaultUserAgent      string  = "sreader/1.0"
 
 	// Default external applications
 	defaultPlayer  string = "mpv"
@@ -84,9 +180,10 @@ var (
 		URLs: nil,
 
 		// Paths
-		DBFile:  defaultDBFile,
-		LogFile: defaultLogFile,
-		TmpDir:  defaultTmpDir,
+		DBFile:     defaultDBFile,
+		LogFile:    defaultLogFile,
+		CookieFile: defaultCookieFile,
+		StatusFile: defaultStatusFile,
 
 		// Colors
 		BG:              defaultBG,
@@ -101,15 +198,39 @@ var (
 		SelectedDescBG:  defaultSelectedDescBG,
 
 		// Keys
-		UpKey:      defaultUpKey,
-		DownKey:    defaultDownKey,
-		LeftKey:    defaultLeftKey,
-		RightKey:   defaultRightKey,
-		QuitKey:    defaultQuitKey,
-		SyncKey:    defaultSyncKey,
-		BrowserKey: defaultBrowserKey,
-		PlayerKey:  defaultPlayerKey,
-		FilterKey:  defaultFilterKey,
+		UpKey:        defaultUpKey,
+		DownKey:      defaultDownKey,
+		LeftKey:      defaultLeftKey,
+		RightKey:     defaultRightKey,
+		QuitKey:      defaultQuitKey,
+		SyncKey:      defaultSyncKey,
+		ForceSyncKey: defaultForceSyncKey,
+		BrowserKey:   defaultBrowserKey,
+		PlayerKey:    defaultPlayerKey,
+		FilterKey:    defaultFilterKey,
+		SearchKey:    defaultSearchKey,
+		StarKey:      defaultStarKey,
+		ReadKey:      defaultReadKey,
+		ReadAllKey:   defaultReadAllKey,
+		DiffKey:      defaultDiffKey,
+		AddKey:       defaultAddKey,
+		RemoveKey:    defaultRemoveKey,
+		RenameKey:    defaultRenameKey,
+		PauseKey:     defaultPauseKey,
+		InfoKey:      defaultInfoKey,
+
+		// Fetching
+		Timeout:        defaultTimeout,
+		ConnectTimeout: defaultConnectTimeout,
+		Retries:        defaultRetries,
+		RetryDelay:     defaultRetryDelay,
+		Workers:        defaultWorkers,
+		HostWorkers:    defaultHostWorkers,
+		HostRate:       defaultHostRate,
+		MaxFeedSize:    defaultMaxFeedSize,
+		Interval:       defaultInterval,
+		MaxInterval:    defaultMaxInterval,
+		UserAgent:      defaultUserAgent,
 
 		// External applications
 		Player:  defaultPlayer,
@@ -117,6 +238,71 @@ var (
 	}
 )
 
+// The defaults, for reloading the configuration
+var defaults = *Config
+
+// Get the settings for the feed at url, or an empty FeedConfig if it has none
+func (c *SreaderConfig) Feed(url string) FeedConfig {
+	if fc := c.Feeds[url]; fc != nil {
+		return *fc
+	}
+	return FeedConfig{}
+}
+
+// Get the retention settings for the feed at url
+func (c *SreaderConfig) Retention(url string) (maxEntries, maxAgeDays int) {
+	fc := c.Feed(url)
+	maxEntries, maxAgeDays = c.MaxEntries, c.MaxAgeDays
+	if fc.MaxEntries != nil {
+		maxEntries = *fc.MaxEntries
+	}
+	if fc.MaxAgeDays != nil {
+		maxAgeDays = *fc.MaxAgeDays
+	}
+	return maxEntries, maxAgeDays
+}
+
+// Get whether entries of the feed at url are marked unread when edited
+func (c *SreaderConfig) MarkEditedUnread(url string) bool {
+	if fc := c.Feed(url); fc.UnreadOnEdit != nil {
+		return *fc.UnreadOnEdit
+	}
+	return c.UnreadOnEdit
+}
+
+// Get the time between syncs of the feed at url
+func (c *SreaderConfig) SyncInterval(url string) time.Duration {
+	interval := c.Interval
+	if fc := c.Feed(url); fc.Interval != nil {
+		interval = *fc.Interval
+	}
+	return time.Duration(interval) * time.Minute
+}
+
+// Get whether the feed at url keeps and sends cookies
+func (c *SreaderConfig) UseCookies(url string) bool {
+	if fc := c.Feed(url); fc.Cookies != nil {
+		return *fc.Cookies
+	}
+	return c.Cookies
+}
+
+// Get the proxy, CA bundle and client certificate for the feed at url
+func (c *SreaderConfig) Connection(url string) (proxy, caFile, clientCert, clientKey string) {
+	fc := c.Feed(url)
+	proxy, caFile, clientCert, clientKey = c.Proxy, c.CAFile, c.ClientCert, c.ClientKey
+	if fc.Proxy != "" {
+		proxy = fc.Proxy
+	}
+	if fc.CAFile != "" {
+		caFile = fc.CAFile
+	}
+	if fc.ClientCert != "" {
+		clientCert, clientKey = fc.ClientCert, fc.ClientKey
+	}
+	return proxy, caFile, clientCert, clientKey
+}
+
 func ExpandHome(path string) string {
 	if path == "" {
 		return ""
@@ -128,18 +314,7 @@ func ExpandHome(path string) string {
 }
 
 func LoadConfig(path string) {
-	// Load environment variables if set
-	if envPlayer := os.Getenv("PLAYER"); envPlayer != "" {
-		Config.Player = envPlayer
-	}
-	if envBrowser := os.Getenv("BROWSER"); envBrowser != "" {
-		Config.Browser = envBrowser
-	}
-	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
-		Config.DBFile = dataHome + "/sreader/sreader.db"
-		Config.LogFile = dataHome + "/sreader/sreader.log"
-		Config.TmpDir = dataHome + "/sreader"
-	}
+	applyEnvironment(Config)
 
 	// Load config file
 	if path != "" {
@@ -155,21 +330,52 @@ func LoadConfig(path string) {
 		}
 	}
 
-	if Config.URLs == nil {
-		log.Fatalln("No URLs in configuration.")
+	expandPaths(Config)
+	log.Println("Configuration loaded successfully.")
+}
+
+// Load the configuration file at path again, from the defaults, e.g. after
+// it was edited. If the file cannot be read, the current configuration is
+// kept.
+func ReloadConfig(path string) error {
+	c := defaults
+	applyEnvironment(&c)
+	if _, err := toml.DecodeFile(ExpandHome(path), &c); err != nil {
+		return err
 	}
+	expandPaths(&c)
+	Config = &c
+	log.Println("Configuration reloaded successfully.")
+	return nil
+}
 
-	// Expand tilde in paths
-	Config.DBFile = ExpandHome(Config.DBFile)
-	Config.LogFile = ExpandHome(Config.LogFile)
-	Config.TmpDir = ExpandHome(Config.TmpDir)
+// Load environment variables if set
+func applyEnvironment(c *SreaderConfig) {
+	if envPlayer := os.Getenv("PLAYER"); envPlayer != "" {
+		c.Player = envPlayer
+	}
+	if envBrowser := os.Getenv("BROWSER"); envBrowser != "" {
+		c.Browser = envBrowser
+	}
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		c.DBFile = dataHome + "/sreader/sreader.db"
+		c.LogFile = dataHome + "/sreader/sreader.log"
+		c.CookieFile = dataHome + "/sreader/cookies.json"
+		c.StatusFile = dataHome + "/sreader/daemon.json"
+	}
+}
 
-	// Make directories if non-existent
-	os.MkdirAll(getDirectoryOfFile(Config.DBFile), 0700)
-	os.MkdirAll(getDirectoryOfFile(Config.LogFile), 0700)
-	os.MkdirAll(Config.TmpDir, 0700)
+// Expand tilde in paths and make their directories if non-existent
+func expandPaths(c *SreaderConfig) {
+	c.DBFile = ExpandHome(c.DBFile)
+	c.LogFile = ExpandHome(c.LogFile)
+	c.CookieFile = ExpandHome(c.CookieFile)
+	c.StatusFile = ExpandHome(c.StatusFile)
 
-	log.Println("Configuration loaded successfully.")
+	os.MkdirAll(getDirectoryOfFile(c.DBFile), 0700)
+	os.MkdirAll(getDirectoryOfFile(c.LogFile), 0700)
+	os.MkdirAll(getDirectoryOfFile(c.CookieFile), 0700)
+	os.MkdirAll(getDirectoryOfFile(c.StatusFile), 0700)
 }
 
 func WriteDefaultConfig(path string) {
@@ -0,0 +1,115 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/bmoneill/sreader/config"
+)
+
+// Output of secret commands by command, so that each runs once per process
+var secrets = struct {
+	sync.Mutex
+	values map[string]string
+}{values: make(map[string]string)}
+
+// Create a GET request for the feed at feedURL, with the user agent,
+// headers and credentials configured for it
+func newRequest(ctx context.Context, feedURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", feedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	fc := config.Config.Feed(feedURL)
+	userAgent := config.Config.UserAgent
+	if fc.UserAgent != "" {
+		userAgent = fc.UserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	for name, value := range fc.Headers {
+		req.Header.Set(name, value)
+	}
+	for name, command := range fc.HeaderCommands {
+		value, err := secret("", command)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(name, value)
+	}
+
+	if fc.Username != "" {
+		password, err := secret(fc.Password, fc.PasswordCommand)
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(fc.Username, password)
+	}
+
+	token, err := secret(fc.Token, fc.TokenCommand)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return req, nil
+}
+
+// Get a client like client that drops the headers configured for the feed at
+// feedURL when redirected to another host, since they may hold credentials.
+// The standard client only drops Authorization and Cookie headers.
+func withHeaderRedirects(client *http.Client, feedURL string) *http.Client {
+	fc := config.Config.Feed(feedURL)
+	if len(fc.Headers) == 0 && len(fc.HeaderCommands) == 0 {
+		return client
+	}
+
+	c := *client
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if req.URL.Host != via[0].URL.Host {
+			for name := range fc.Headers {
+				req.Header.Del(name)
+			}
+			for name := range fc.HeaderCommands {
+				req.Header.Del(name)
+			}
+		}
+		return nil
+	}
+	return &c
+}
+
+// Get a secret: the first line printed by command, or value if there is no
+// command. Commands run with sh, e.g. "pass show feeds/example".
+func secret(value, command string) (string, error) {
+	if command == "" {
+		return value, nil
+	}
+
+	secrets.Lock()
+	defer secrets.Unlock()
+
+	if value, ok := secrets.values[command]; ok {
+		return value, nil
+	}
+
+	out, err := exec.Command("sh", "-c", command).Output()
+	if err != nil {
+		return "", fmt.Errorf("secret command %q failed: %w", command, err)
+	}
+	value, _, _ = strings.Cut(string(out), "\n")
+	value = strings.TrimSuffix(value, "\r")
+	secrets.values[command] = value
+	return value, nil
+}
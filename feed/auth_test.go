@@ -0,0 +1,73 @@
+package feed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bmoneill/sreader/config"
+)
+
+// Use fc as the settings of the feed at url for the rest of the test
+func setFeedConfig(t *testing.T, url string, fc *config.FeedConfig) {
+	t.Helper()
+	saved := config.Config.Feeds
+	config.Config.Feeds = map[string]*config.FeedConfig{url: fc}
+	t.Cleanup(func() { config.Config.Feeds = saved })
+}
+
+func TestHeadersDroppedOnCrossHostRedirect(t *testing.T) {
+	headers := make(chan http.Header, 2)
+	record := func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+	}
+	other := httptest.NewServer(http.HandlerFunc(record))
+	defer other.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/away":
+			http.Redirect(w, r, other.URL+"/feed", http.StatusFound)
+		case "/here":
+			http.Redirect(w, r, "/feed", http.StatusFound)
+		default:
+			record(w, r)
+		}
+	}))
+	defer origin.Close()
+
+	for _, test := range []struct {
+		path string
+		kept bool
+	}{
+		{"/here", true},
+		{"/away", false},
+	} {
+		feedURL := origin.URL + test.path
+		setFeedConfig(t, feedURL, &config.FeedConfig{
+			Headers:        map[string]string{"X-Api-Key": "secret"},
+			HeaderCommands: map[string]string{"X-Command-Key": "echo secret"},
+		})
+
+		req, err := newRequest(context.Background(), feedURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := newHTTPClients().get(feedURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		got := <-headers
+		for _, name := range []string{"X-Api-Key", "X-Command-Key"} {
+			if kept := got.Get(name) != ""; kept != test.kept {
+				t.Errorf("%s: %s sent = %v, want %v", test.path, name, kept, test.kept)
+			}
+		}
+	}
+}
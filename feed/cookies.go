@@ -0,0 +1,149 @@
+package feed
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bmoneill/sreader/config"
+)
+
+// Cookie jar shared by the feeds that use cookies, loaded on first use
+var (
+	jar     *cookieJar
+	jarOnce sync.Once
+)
+
+// Cookie jar saved to a file between runs
+type cookieJar struct {
+	*cookiejar.Jar
+	path    string
+	mu      sync.Mutex
+	cookies map[string]*savedCookie
+	changed bool
+}
+
+// A cookie as saved, with the URL that set it
+type savedCookie struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+// Get a client like client that sends and stores cookies if the feed at
+// feedURL uses them
+func withCookies(client *http.Client, feedURL string) *http.Client {
+	if !config.Config.UseCookies(feedURL) {
+		return client
+	}
+
+	jarOnce.Do(func() { jar = loadCookieJar(config.Config.CookieFile) })
+	c := *client
+	c.Jar = jar
+	return &c
+}
+
+// Load the cookies saved at path. Starts empty if there are none.
+func loadCookieJar(path string) *cookieJar {
+	inner, _ := cookiejar.New(nil)
+	j := &cookieJar{Jar: inner, path: path, cookies: make(map[string]*savedCookie)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Failed to read cookies:", err.Error())
+		}
+		return j
+	}
+
+	var saved []*savedCookie
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Println("Failed to read cookies:", err.Error())
+		return j
+	}
+	for _, c := range saved {
+		if u, err := url.Parse(c.URL); err == nil && c.Cookie != nil {
+			j.SetCookies(u, []*http.Cookie{c.Cookie})
+		}
+	}
+	j.changed = false
+	return j
+}
+
+// Store cookies set by a response, remembering them for saveCookies
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	setBy := (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+	for _, c := range cookies {
+		domain := c.Domain
+		if domain == "" {
+			domain = u.Hostname()
+		}
+		key := domain + ";" + c.Path + ";" + c.Name
+
+		// Max-Age is relative to now, so save the time it expires at instead
+		saved := *c
+		saved.Raw, saved.Unparsed = "", nil
+		if c.MaxAge > 0 {
+			saved.Expires, saved.MaxAge = now.Add(time.Duration(c.MaxAge)*time.Second), 0
+		}
+
+		if c.MaxAge < 0 || (!saved.Expires.IsZero() && saved.Expires.Before(now)) {
+			delete(j.cookies, key)
+		} else {
+			j.cookies[key] = &savedCookie{URL: setBy, Cookie: &saved}
+		}
+	}
+	j.changed = true
+}
+
+// Save the cookie jar, if it was used and changed
+func saveCookies() {
+	if jar == nil {
+		return
+	}
+
+	jar.mu.Lock()
+	defer jar.mu.Unlock()
+
+	if !jar.changed {
+		return
+	}
+
+	var saved []*savedCookie
+	now := time.Now()
+	for _, c := range jar.cookies {
+		if c.Cookie.Expires.IsZero() || c.Cookie.Expires.After(now) {
+			saved = append(saved, c)
+		}
+	}
+
+	data, err := json.Marshal(saved)
+	if err == nil {
+		err = writeFileAtomic(jar.path, data)
+	}
+	if err != nil {
+		log.Println("Failed to save cookies:", err.Error())
+		return
+	}
+	jar.changed = false
+}
+
+// Write a file by writing a new one and renaming it over the old one, so that
+// a crash cannot leave it half written and readers never see it half written
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
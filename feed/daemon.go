@@ -0,0 +1,186 @@
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/bmoneill/sreader/config"
+)
+
+// Longest time the daemon sleeps between checks for due feeds, so that it
+// notices new subscriptions. It also rewrites its status file this often.
+const daemonPoll = time.Minute
+
+// State of "sreader daemon", written to config.Config.StatusFile
+type DaemonStatus struct {
+	PID       int       `json:"pid"`
+	Started   time.Time `json:"started"`
+	Updated   time.Time `json:"updated"` // When the status was written
+	Syncing   bool      `json:"syncing"`
+	Stopped   bool      `json:"stopped"`
+	LastSync  time.Time `json:"last_sync"` // When the last sync finished
+	NextSync  time.Time `json:"next_sync"` // When the next feed is due, zero if none is
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Whether the daemon that wrote the status is still running. A daemon that
+// was killed without stopping cleanly stops updating its status.
+func (d *DaemonStatus) Running() bool {
+	return !d.Stopped && time.Since(d.Updated) < 3*daemonPoll
+}
+
+// Read the status file of the daemon. Returns nil if there is none.
+func ReadDaemonStatus() (*DaemonStatus, error) {
+	data, err := os.ReadFile(config.Config.StatusFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var status DaemonStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func writeDaemonStatus(status *DaemonStatus) {
+	status.Updated = time.Now()
+	data, err := json.Marshal(status)
+	if err == nil {
+		err = writeFileAtomic(config.Config.StatusFile, data)
+	}
+	if err != nil {
+		log.Println("Failed to write daemon status:", err.Error())
+	}
+}
+
+// Sync feeds as they become due until SIGINT or SIGTERM, which also cancel
+// a running sync. On SIGHUP, reload is called to reload the configuration
+// and the feeds that are due are synced right away.
+func Daemon(s Store, reload func() error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Handle signals while syncing too: SIGINT and SIGTERM cancel ctx, and
+	// hangups are passed on to the loop below
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	hangups := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if sig != syscall.SIGHUP {
+					log.Println("Received", sig.String()+", shutting down.")
+					cancel()
+					return
+				}
+				select {
+				case hangups <- struct{}{}:
+				default:
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	status := &DaemonStatus{PID: os.Getpid(), Started: time.Now()}
+	defer func() {
+		status.Syncing, status.Stopped = false, true
+		writeDaemonStatus(status)
+	}()
+	log.Println("Daemon started, writing status to", config.Config.StatusFile)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		hangup := false
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hangups:
+			hangup = true
+			log.Println("Received hangup, reloading configuration...")
+			if err := reload(); err != nil {
+				log.Println("Failed to reload configuration:", err.Error())
+				status.LastError = "reloading configuration: " + err.Error()
+			}
+			// Commands may print different secrets now
+			secrets.Lock()
+			clear(secrets.values)
+			secrets.Unlock()
+		case <-timer.C:
+		}
+
+		if due, _ := checkDue(s); due > 0 || hangup {
+			syncForDaemon(ctx, s, status)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		_, status.NextSync = checkDue(s)
+		wait := daemonPoll
+		if until := time.Until(status.NextSync); until > 0 && until < wait {
+			wait = until
+		}
+		writeDaemonStatus(status)
+		timer.Reset(wait)
+	}
+}
+
+// Sync the feeds that are due, recording the outcome in status
+func syncForDaemon(ctx context.Context, s Store, status *DaemonStatus) {
+	status.Syncing = true
+	writeDaemonStatus(status)
+	err := Sync(ctx, s, false)
+	status.Syncing = false
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		log.Println("Sync interrupted.")
+	case err == ErrSyncRunning:
+		log.Println("Another sync is running, trying again later.")
+	case err != nil:
+		log.Println("Failed to sync:", err.Error())
+		status.LastError = err.Error()
+	default:
+		status.LastSync = time.Now()
+		status.LastError = ""
+	}
+}
+
+// Get the number of feeds that are due and when the next one is, zero if
+// no feed will be
+func checkDue(s Store) (int, time.Time) {
+	feeds, err := s.ListFeeds()
+	if err != nil {
+		log.Println("Failed to list feeds:", err.Error())
+		return 0, time.Time{}
+	}
+
+	now := time.Now()
+	feeds = slices.DeleteFunc(feeds, func(f *Feed) bool { return f.Paused || f.Gone })
+	n := 0
+	for _, f := range feeds {
+		if due(f, now) {
+			n++
+		}
+	}
+	if len(feeds) == 0 {
+		return 0, time.Time{}
+	}
+	next := slices.MinFunc(feeds, func(a, b *Feed) int { return a.NextSync.Compare(b.NextSync) })
+	return n, next.NextSync
+}
@@ -3,270 +3,574 @@ package feed
 import (
 	"database/sql"
 	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/bmoneill/sreader/config"
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/mmcdole/gofeed"
 )
 
-type Entry struct {
-	ID            int64  `json:"id"`
-	FeedID        int64  `json:"feed_id"`
-	URL           string `json:"url"`
-	Title         string `json:"title"`
-	Description   string `json:"description"`
-	Content       string `json:"content"`
-	DatePublished string `json:"date_published"`
-	Read          bool   `json:"read"`
-}
+// Prefix of GUIDs assigned to entries stored before GUIDs were tracked
+const legacyGUIDPrefix = "legacy:"
 
-type Feed struct {
-	ID          int64    `json:"id"`
-	URL         string   `json:"url"`
-	Title       string   `json:"title"`
-	Description string   `json:"description"`
-	LastUpdated string   `json:"last_updated"`
-	Entries     []*Entry `json:"entries,omitempty"`
+// Store backed by an SQLite database file
+type SQLiteStore struct {
+	db     *sql.DB
+	path   string
+	syncMu sync.Mutex // Held with the sync lock file
 }
 
-var conn *sql.DB
+// How long to wait for another connection or process to release the database
+const busyTimeout = 10 * time.Second
+
+var _ Store = (*SQLiteStore)(nil)
 
-// Initialize SQLite database connection and creates the necessary tables if they do not exist.
-func InitDB() {
+// Open the SQLite database at path and bring the schema up to date.
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
 	log.Println("Loading database...")
-	// Initialize the SQLite database connection
-	var err error
-	conn, err = sql.Open("sqlite3", config.Config.DBFile)
+	// Initialize the SQLite database connection. WAL mode lets the UI read while
+	// another process syncs, and immediate transactions wait for the busy
+	// timeout instead of failing when a read lock can't be upgraded.
+	dsn := path + "?_journal_mode=WAL&_txlock=immediate&_busy_timeout=" +
+		strconv.FormatInt(busyTimeout.Milliseconds(), 10)
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
-		log.Fatalln("Failed to load database.", err.Error())
+		return nil, err
 	}
 
-	// Create the tables if they do not exist
-	_, err = conn.Exec(`CREATE TABLE IF NOT EXISTS feeds (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		url TEXT,
-		title TEXT,
-		description TEXT,
-		last_updated DATETIME DEFAULT CURRENT_TIMESTAMP
-	)`)
+	// Create or upgrade the schema
+	if err = migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	if err != nil {
-		log.Fatalln("Error creating feeds table:", err.Error())
+	if err = initSearchIndex(db); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	_, err = conn.Exec(`CREATE TABLE IF NOT EXISTS entries (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		feed_id INTEGER,
-		url TEXT,
-		title TEXT NOT NULL DEFAULT '',
-		description TEXT NOT NULL DEFAULT '',
-		content TEXT NOT NULL DEFAULT '',
-		date_published DATETIME,
-		read INTEGER DEFAULT 0,
-		FOREIGN KEY(feed_id) REFERENCES feeds(id)
-	)`)
+	log.Println("Database loaded successfully.")
+	return &SQLiteStore{db: db, path: path}, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
 
+// Adds a feed to the database, or updates its metadata if it already exists.
+func (s *SQLiteStore) AddFeed(feed *Feed) (int64, error) {
+	tx, err := s.db.Begin()
 	if err != nil {
-		log.Fatalln("Error creating entries table:", err.Error())
+		return 0, err
 	}
+	defer tx.Rollback()
 
-	log.Println("Database loaded successfully.")
+	id, err := addFeed(tx, feed)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
 }
 
-// Adds a feed to the database.
-// If the feed already exists, it adds any new entries.
-// If the feed does not exist, it inserts a new feed and its entries.
-func AddFeed(feed *gofeed.Feed) (int64, error) {
-	var exists bool
+func addFeed(tx *sql.Tx, feed *Feed) (int64, error) {
 	var id int64
-	var stmt *sql.Stmt
-	var res sql.Result
-	var err error
 
 	// Check if the feed already exists
-	err = conn.QueryRow("SELECT EXISTS(SELECT 1 FROM feeds WHERE url = ?)", feed.Link).Scan(&exists)
+	err := tx.QueryRow("SELECT id FROM feeds WHERE url = ?", feed.URL).Scan(&id)
+	if err == nil {
+		log.Println("Feed already exists in DB, updating:", feed.URL)
+		_, err = tx.Exec(`UPDATE feeds SET site_url = ?, title = ?, description = ?, language = ?,
+			author = ?, image = ?, generator = ?, ttl = ?, skip_hours = ?, skip_days = ?,
+			etag = ?, last_modified = ? WHERE id = ?`,
+			feed.SiteURL, feed.Title, feed.Description, feed.Language,
+			feed.Author, feed.Image, feed.Generator, feed.TTL, joinInts(feed.SkipHours), joinInts(feed.SkipDays),
+			feed.ETag, feed.LastModified, id)
+		return id, err
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	// Insert new feed into the database
+	log.Println("Adding new feed to DB: ", feed.URL)
+	res, err := tx.Exec(`INSERT INTO feeds (url, site_url, title, description, language, author, image, generator, ttl,
+			skip_hours, skip_days, etag, last_modified)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		feed.URL, feed.SiteURL, feed.Title, feed.Description, feed.Language,
+		feed.Author, feed.Image, feed.Generator, feed.TTL,
+		joinInts(feed.SkipHours), joinInts(feed.SkipDays), feed.ETag, feed.LastModified)
 	if err != nil {
-		log.Println("Error checking if feed exists:", err.Error())
 		return 0, err
 	}
+	return res.LastInsertId()
+}
 
-	if exists {
-		// Get ID of existing feed
-		log.Println("Feed already exists in DB, updating:", feed.Link)
-		stmt, err = conn.Prepare("SELECT id FROM feeds WHERE url = ?")
-		if err != nil {
-			log.Println("Error preparing statement:", err.Error())
-			return 0, err
-		}
-		defer stmt.Close()
-		err = stmt.QueryRow(feed.Link).Scan(&id)
-		if err != nil {
-			log.Println("Error querying feed ID:", err.Error())
-			return 0, err
-		}
-	} else {
-		// Insert new feed into the database
-		log.Println("Adding new feed to DB: ", feed.Link)
-		stmt, err = conn.Prepare("INSERT INTO feeds (url, title, description) VALUES (?, ?, ?)")
-		if err != nil {
-			log.Println("Error preparing statement:", err.Error())
-			return 0, err
-		}
-		defer stmt.Close()
-		res, err = stmt.Exec(feed.Link, feed.Title, feed.Description)
+// Adds an entry to the database, or updates it if an entry with the same GUID
+// already exists in the feed. Authors, categories and enclosures are replaced.
+// If the entry is undated (dated is false), an existing entry keeps its
+// original publication time.
+func (s *SQLiteStore) AddEntry(entry *Entry, dated bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-		if err != nil {
-			log.Println("Error inserting feed:", err.Error())
+	w, err := newEntryWriter(tx)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if err = w.write(entry, dated, false); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Adds a feed if it does not exist and adds or updates its entries, all in a
+// single transaction.
+func (s *SQLiteStore) UpdateFeed(feed *Feed, updates []EntryUpdate) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := addFeed(tx, feed)
+	if err != nil {
+		return 0, err
+	}
+
+	w, err := newEntryWriter(tx)
+	if err != nil {
+		return 0, err
+	}
+	defer w.Close()
+
+	for _, u := range updates {
+		u.Entry.FeedID = id
+		if err = w.write(u.Entry, u.Dated, u.MarkUnread); err != nil {
 			return 0, err
 		}
-		id, _ = res.LastInsertId()
 	}
 
-	// Add entries
-	for _, item := range feed.Items {
-		err = AddEntry(id, item.Link, item.Title, item.Description, item.PublishedParsed.UTC().Format("Tue, 15 Nov 1994 12:45:26 GMT"))
+	return id, tx.Commit()
+}
+
+// Prepared statements for writing entries within a transaction
+type entryWriter struct {
+	adopt            *sql.Stmt
+	archive          *sql.Stmt
+	upsert           *sql.Stmt
+	deleteAuthors    *sql.Stmt
+	deleteCategories *sql.Stmt
+	deleteEnclosures *sql.Stmt
+	insertAuthor     *sql.Stmt
+	insertCategory   *sql.Stmt
+	insertEnclosure  *sql.Stmt
+}
+
+func newEntryWriter(tx *sql.Tx) (*entryWriter, error) {
+	w := &entryWriter{}
+	stmts := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		// Adopts an entry stored before GUIDs were tracked
+		{&w.adopt, "UPDATE OR IGNORE entries SET guid = ? WHERE feed_id = ? AND guid = ?"},
+		// Keeps the stored version of an entry whose text changed. Rows never
+		// seen by a sync predate content tracking, so their text isn't comparable.
+		{&w.archive, `INSERT INTO entry_revisions (entry_id, title, description, content, updated, replaced)
+			SELECT id, title, description, content, updated, ? FROM entries
+			WHERE feed_id = ? AND guid = ? AND last_seen != 0
+				AND (title IS NOT ? OR description IS NOT ? OR content IS NOT ?)`},
+		{&w.upsert, `INSERT INTO entries (feed_id, guid, url, title, description, content, image, published, updated, last_seen) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (feed_id, guid) DO UPDATE SET
+				url = excluded.url,
+				title = excluded.title,
+				description = excluded.description,
+				content = excluded.content,
+				image = excluded.image,
+				published = CASE WHEN ? THEN excluded.published ELSE published END,
+				updated = excluded.updated,
+				last_seen = excluded.last_seen,
+				read = CASE WHEN ? THEN 0 ELSE read END
+			RETURNING id`},
+		{&w.deleteAuthors, "DELETE FROM entry_authors WHERE entry_id = ?"},
+		{&w.deleteCategories, "DELETE FROM entry_categories WHERE entry_id = ?"},
+		{&w.deleteEnclosures, "DELETE FROM entry_enclosures WHERE entry_id = ?"},
+		{&w.insertAuthor, "INSERT INTO entry_authors (entry_id, position, name, email) VALUES (?, ?, ?, ?)"},
+		{&w.insertCategory, "INSERT INTO entry_categories (entry_id, position, name) VALUES (?, ?, ?)"},
+		{&w.insertEnclosure, "INSERT INTO entry_enclosures (entry_id, position, url, type, length) VALUES (?, ?, ?, ?, ?)"},
+	}
+
+	for _, s := range stmts {
+		stmt, err := tx.Prepare(s.query)
 		if err != nil {
-			log.Println("Error adding entry:", err.Error())
-			return 0, err
+			w.Close()
+			return nil, err
 		}
+		*s.stmt = stmt
 	}
+	return w, nil
+}
 
-	log.Println(feed.Title, "added/updated successfully,", len(feed.Items), "entries.")
-	return id, err
+func (w *entryWriter) Close() {
+	for _, stmt := range []*sql.Stmt{w.adopt, w.archive, w.upsert, w.deleteAuthors, w.deleteCategories,
+		w.deleteEnclosures, w.insertAuthor, w.insertCategory, w.insertEnclosure} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
 }
 
-// Adds an entry to the database if it does not already exist.
-func AddEntry(feedID int64, url, title, description string, datePublished string) error {
-	// Check if the entry already exists (by feed_id and date_published)
-	var exists bool
-	err := conn.QueryRow("SELECT EXISTS(SELECT 1 FROM entries WHERE feed_id = ? AND date_published = ?)", feedID, datePublished).Scan(&exists)
+// Insert or update an entry and replace its authors, categories and enclosures.
+// If the entry's text changed, the stored version becomes a revision and, if
+// markUnread is set, the entry is marked unread. Sets entry.ID.
+func (w *entryWriter) write(entry *Entry, dated, markUnread bool) error {
+	if entry.URL != "" {
+		if _, err := w.adopt.Exec(entry.GUID, entry.FeedID, legacyGUIDPrefix+entry.URL); err != nil {
+			return err
+		}
+	}
+
+	res, err := w.archive.Exec(unixSeconds(entry.LastSeen), entry.FeedID, entry.GUID,
+		entry.Title, entry.Description, entry.Content)
 	if err != nil {
 		return err
 	}
-	if exists {
-		return nil // Entry already exists, do nothing
+	changed, err := res.RowsAffected()
+	if err != nil {
+		return err
 	}
 
-	// Insert new entry into the database
-	stmt, err := conn.Prepare("INSERT INTO entries (feed_id, url, title, description, date_published) VALUES (?, ?, ?, ?, ?)")
+	err = w.upsert.QueryRow(entry.FeedID, entry.GUID, entry.URL, entry.Title, entry.Description,
+		entry.Content, entry.Image, unixSeconds(entry.Published), unixSeconds(entry.Updated), unixSeconds(entry.LastSeen),
+		dated, markUnread && changed > 0).Scan(&entry.ID)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(feedID, url, title, description, datePublished)
-	return err
+	for _, stmt := range []*sql.Stmt{w.deleteAuthors, w.deleteCategories, w.deleteEnclosures} {
+		if _, err := stmt.Exec(entry.ID); err != nil {
+			return err
+		}
+	}
+
+	for i, author := range entry.Authors {
+		if _, err := w.insertAuthor.Exec(entry.ID, i, author.Name, author.Email); err != nil {
+			return err
+		}
+	}
+
+	for i, category := range entry.Categories {
+		if _, err := w.insertCategory.Exec(entry.ID, i, category); err != nil {
+			return err
+		}
+	}
+
+	for i, enclosure := range entry.Enclosures {
+		if _, err := w.insertEnclosure.Exec(entry.ID, i, enclosure.URL, enclosure.Type, enclosure.Length); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get all entries for feed with feedID, newest first
+func (s *SQLiteStore) GetEntries(feedID int64) ([]*Entry, error) {
+	return s.queryEntries("WHERE e.feed_id = ? ORDER BY e.published DESC, e.id", feedID)
+}
+
+// Get starred entries of all feeds, newest first
+func (s *SQLiteStore) GetStarredEntries() ([]*Entry, error) {
+	return s.queryEntries("WHERE e.starred = 1 ORDER BY e.published DESC, e.id")
 }
 
-// Get all entries for feed with feedID
-func GetEntries(feedID int) []*Entry {
-	// Retrieve entries for a specific feed
-	rows, err := conn.Query("SELECT id, url, title, description, date_published, read, content FROM entries WHERE feed_id = ?", feedID)
+// Get the earlier versions of an entry, newest first
+func (s *SQLiteStore) GetRevisions(entryID int64) ([]*Revision, error) {
+	rows, err := s.db.Query(`SELECT id, entry_id, title, description, content, updated, replaced
+		FROM entry_revisions WHERE entry_id = ? ORDER BY id DESC`, entryID)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 	defer rows.Close()
 
-	var entries []*Entry
-
-	// Scan rows into Entry structs
+	var revisions []*Revision
 	for rows.Next() {
 		var (
-			id            int
-			url           string
-			title         string
-			description   string
-			content       string
-			datePublished string
-			read          int
+			rev               Revision
+			updated, replaced int64
 		)
-
-		err := rows.Scan(&id, &url, &title, &description, &datePublished, &read, &content)
+		err := rows.Scan(&rev.ID, &rev.EntryID, &rev.Title, &rev.Description, &rev.Content, &updated, &replaced)
 		if err != nil {
-			log.Println("Error scanning entry:", err.Error())
-			return nil
+			return nil, err
 		}
-
-		entry := &Entry{
-			ID:            int64(id),
-			FeedID:        int64(feedID),
-			URL:           url,
-			Title:         title,
-			Description:   description,
-			Content:       content,
-			DatePublished: datePublished,
-			Read:          read == 1,
-		}
-		entries = append(entries, entry)
+		rev.Updated = unixTime(updated)
+		rev.Replaced = unixTime(replaced)
+		revisions = append(revisions, &rev)
 	}
+	return revisions, rows.Err()
+}
+
+// Columns read by scanEntry, from the entries table aliased as e
+const entryColumns = "e.id, e.feed_id, e.guid, COALESCE(e.url, ''), e.title, e.description, e.content, e.image, e.published, e.updated, e.read, e.starred, e.last_seen, " +
+	"(SELECT COUNT(*) FROM entry_revisions r WHERE r.entry_id = e.id)"
 
-	return entries
+type rowScanner interface {
+	Scan(dest ...any) error
 }
 
-func GetFeedByURL(url string) *Feed {
-	row := conn.QueryRow("SELECT id, url, title, description, last_updated FROM feeds WHERE url = ?", url)
+// Scan a row selected with entryColumns into an Entry.
+// Any columns following entryColumns are scanned into extra.
+func scanEntry(row rowScanner, extra ...any) (*Entry, error) {
 	var (
-		id          int64
-		dbURL       string
-		title       string
-		description string
-		lastUpdated string
+		entry                        Entry
+		published, updated, lastSeen int64
 	)
-	err := row.Scan(&id, &dbURL, &title, &description, &lastUpdated)
+
+	dest := []any{&entry.ID, &entry.FeedID, &entry.GUID, &entry.URL, &entry.Title, &entry.Description,
+		&entry.Content, &entry.Image, &published, &updated, &entry.Read, &entry.Starred, &lastSeen, &entry.Revisions}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+
+	entry.Published = unixTime(published)
+	entry.Updated = unixTime(updated)
+	entry.LastSeen = unixTime(lastSeen)
+	return &entry, nil
+}
+
+// Select entries with the given WHERE/ORDER BY clauses, including authors,
+// categories and enclosures
+func (s *SQLiteStore) queryEntries(clauses string, args ...any) ([]*Entry, error) {
+	rows, err := s.db.Query("SELECT "+entryColumns+" FROM entries e "+clauses, args...)
 	if err != nil {
-		return nil
+		return nil, err
 	}
+	defer rows.Close()
 
-	feed := &Feed{
-		ID:          id,
-		URL:         dbURL,
-		Title:       title,
-		Description: description,
-		LastUpdated: lastUpdated,
-		Entries:     GetEntries(int(id)),
+	var entries []*Entry
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
-	return feed
+
+	return entries, s.loadEntryChildren(entries)
 }
 
-// Retrieve all feeds
-func GetFeeds() []*Feed {
-	var feeds []*Feed
-	for _, url := range config.Config.URLs {
-		log.Println("Checking feed URL:", *url)
-		if len(*url) > 0 {
-			feed := GetFeedByURL(*url)
-			if feed != nil {
-				log.Println("Feed found in database:", *url)
-				feeds = append(feeds, feed)
-			} else {
-				log.Println("Feed not found in database:", *url)
-			}
+// Fill in authors, categories and enclosures for entries
+func (s *SQLiteStore) loadEntryChildren(entries []*Entry) error {
+	byID := make(map[int64]*Entry, len(entries))
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		byID[entry.ID] = entry
+		ids = append(ids, strconv.FormatInt(entry.ID, 10))
+	}
+
+	// Pass the IDs as a JSON array to avoid building a huge IN (...) list
+	const inEntries = "entry_id IN (SELECT value FROM json_each(?)) ORDER BY entry_id, position"
+	idList := "[" + strings.Join(ids, ",") + "]"
+
+	rows, err := s.db.Query("SELECT entry_id, name, email FROM entry_authors WHERE "+inEntries, idList)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int64
+		var author Person
+		if err := rows.Scan(&id, &author.Name, &author.Email); err != nil {
+			rows.Close()
+			return err
+		}
+		if entry := byID[id]; entry != nil {
+			entry.Authors = append(entry.Authors, author)
 		}
 	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
 
-	return feeds
-}
+	rows, err = s.db.Query("SELECT entry_id, name FROM entry_categories WHERE "+inEntries, idList)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int64
+		var category string
+		if err := rows.Scan(&id, &category); err != nil {
+			rows.Close()
+			return err
+		}
+		if entry := byID[id]; entry != nil {
+			entry.Categories = append(entry.Categories, category)
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
 
-// Mark an entry as read
-func MarkRead(entryID int) error {
-	stmt, err := conn.Prepare("UPDATE entries SET read = 1 WHERE id = ?")
+	rows, err = s.db.Query("SELECT entry_id, url, type, length FROM entry_enclosures WHERE "+inEntries, idList)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var enclosure Enclosure
+		if err := rows.Scan(&id, &enclosure.URL, &enclosure.Type, &enclosure.Length); err != nil {
+			return err
+		}
+		if entry := byID[id]; entry != nil {
+			entry.Enclosures = append(entry.Enclosures, enclosure)
+		}
+	}
 
-	_, err = stmt.Exec(entryID)
-	return err
+	return rows.Err()
+}
+
+// Columns read by scanFeed
+const feedColumns = "id, url, site_url, title, custom_title, paused, description, language, author, image, generator, ttl, etag, last_modified, last_updated, " +
+	"last_attempt, http_status, failures, last_error, gone, skip_hours, skip_days, next_sync, newest, unchanged"
+
+// Scan a row selected with feedColumns into a Feed
+func scanFeed(row rowScanner) (*Feed, error) {
+	var (
+		feed                                       Feed
+		lastUpdated, lastAttempt, nextSync, newest int64
+		skipHours, skipDays                        string
+	)
+	err := row.Scan(&feed.ID, &feed.URL, &feed.SiteURL, &feed.Title, &feed.CustomTitle, &feed.Paused, &feed.Description, &feed.Language,
+		&feed.Author, &feed.Image, &feed.Generator, &feed.TTL, &feed.ETag, &feed.LastModified, &lastUpdated,
+		&lastAttempt, &feed.HTTPStatus, &feed.Failures, &feed.LastError, &feed.Gone,
+		&skipHours, &skipDays, &nextSync, &newest, &feed.Unchanged)
+	if err != nil {
+		return nil, err
+	}
+	feed.LastUpdated = unixTime(lastUpdated)
+	feed.LastAttempt = unixTime(lastAttempt)
+	feed.SkipHours = splitInts[int](skipHours)
+	feed.SkipDays = splitInts[time.Weekday](skipDays)
+	feed.NextSync = unixTime(nextSync)
+	feed.Newest = unixTime(newest)
+	return &feed, nil
 }
 
-// Update the last updated time for a feed
-func MarkUpdated(feedID int64) error {
-	stmt, err := conn.Prepare("UPDATE feeds SET last_updated = CURRENT_TIMESTAMP WHERE id = ?")
+func (s *SQLiteStore) ListFeeds() ([]*Feed, error) {
+	rows, err := s.db.Query("SELECT " + feedColumns + " FROM feeds ORDER BY id")
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer rows.Close()
+
+	var feeds []*Feed
+	for rows.Next() {
+		feed, err := scanFeed(rows)
+		if err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, feed)
 	}
-	defer stmt.Close()
+	return feeds, rows.Err()
+}
+
+func (s *SQLiteStore) GetFeedByURL(url string) (*Feed, error) {
+	feed, err := scanFeed(s.db.QueryRow("SELECT "+feedColumns+" FROM feeds WHERE url = ?", url))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	feed.Entries, err = s.GetEntries(feed.ID)
+	return feed, err
+}
+
+// Mark an entry as read or unread
+func (s *SQLiteStore) SetRead(entryID int64, read bool) error {
+	_, err := s.db.Exec("UPDATE entries SET read = ? WHERE id = ?", read, entryID)
+	return err
+}
+
+// Mark all entries of a feed as read
+func (s *SQLiteStore) MarkFeedRead(feedID int64) error {
+	_, err := s.db.Exec("UPDATE entries SET read = 1 WHERE feed_id = ? AND read = 0", feedID)
+	return err
+}
 
-	_, err = stmt.Exec(feedID)
+// Star or unstar an entry. Starred entries are never deleted by pruning.
+func (s *SQLiteStore) SetStarred(entryID int64, starred bool) error {
+	_, err := s.db.Exec("UPDATE entries SET starred = ? WHERE id = ?", starred, entryID)
 	return err
 }
+
+// Record a successful sync, updating the last updated time of a feed
+func (s *SQLiteStore) RecordSuccess(feedID int64, status int) error {
+	now := time.Now().Unix()
+	_, err := s.db.Exec(`UPDATE feeds SET last_updated = ?, last_attempt = ?, http_status = ?,
+		failures = 0, last_error = '', gone = 0 WHERE id = ?`, now, now, status, feedID)
+	return err
+}
+
+// Record a failed sync, counting consecutive failures
+func (s *SQLiteStore) RecordFailure(feedID int64, status int, message string) error {
+	_, err := s.db.Exec(`UPDATE feeds SET last_attempt = ?, http_status = ?,
+		failures = failures + 1, last_error = ?, gone = ? WHERE id = ?`,
+		time.Now().Unix(), status, message, status == http.StatusGone, feedID)
+	return err
+}
+
+// Record when a feed is next due and how often it had nothing new
+func (s *SQLiteStore) SetSchedule(feedID int64, next, newest time.Time, unchanged int) error {
+	_, err := s.db.Exec("UPDATE feeds SET next_sync = ?, newest = ?, unchanged = ? WHERE id = ?",
+		unixSeconds(next), unixSeconds(newest), unchanged, feedID)
+	return err
+}
+
+// Lists of numbers, like the hours a feed is not synced in, are stored
+// comma-separated
+func joinInts[T ~int](values []T) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(s, ",")
+}
+
+func splitInts[T ~int](s string) []T {
+	var values []T
+	for _, field := range strings.Split(s, ",") {
+		if v, err := strconv.Atoi(strings.TrimSpace(field)); err == nil {
+			values = append(values, T(v))
+		}
+	}
+	return values
+}
+
+// Times are stored as unix seconds, with 0 meaning unknown
+func unixSeconds(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func unixTime(seconds int64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0).UTC()
+}
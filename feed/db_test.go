@@ -0,0 +1,86 @@
+package feed
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// Open a store in a new database file that is removed after the test
+func openTestStore(tb testing.TB) *SQLiteStore {
+	tb.Helper()
+	s, err := OpenSQLiteStore(filepath.Join(tb.TempDir(), "test.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { s.Close() })
+	return s
+}
+
+// Get n entries as a sync of the feed at url would store them
+func testEntries(url string, n int) []EntryUpdate {
+	fetched := time.Now().UTC().Truncate(time.Second)
+	updates := make([]EntryUpdate, n)
+	for i := range updates {
+		updates[i].Entry = &Entry{
+			GUID:        fmt.Sprintf("%s/%d", url, i),
+			URL:         fmt.Sprintf("%s/%d", url, i),
+			Title:       fmt.Sprintf("Entry %d", i),
+			Description: "Description",
+			Content:     "<p>Content</p>",
+			Published:   fetched.Add(-time.Duration(i) * time.Minute),
+			Updated:     fetched.Add(-time.Duration(i) * time.Minute),
+			LastSeen:    fetched,
+			Authors:     []Person{{Name: "Author"}},
+			Categories:  []string{"news"},
+		}
+		updates[i].Dated = true
+	}
+	return updates
+}
+
+func BenchmarkUpdateFeed(b *testing.B) {
+	const url, items = "https://example.com/feed", 3000
+
+	b.Run("UpdateFeed", func(b *testing.B) {
+		for range b.N {
+			b.StopTimer()
+			s := openTestStore(b)
+			updates := testEntries(url, items)
+			b.StartTimer()
+
+			if _, err := s.UpdateFeed(&Feed{URL: url}, updates); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("AddEntry", func(b *testing.B) {
+		for range b.N {
+			b.StopTimer()
+			s := openTestStore(b)
+			updates := testEntries(url, items)
+			b.StartTimer()
+
+			id, err := s.AddFeed(&Feed{URL: url})
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, u := range updates {
+				u.Entry.FeedID = id
+				if err := s.AddEntry(u.Entry, u.Dated); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,220 @@
+package feed
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bmoneill/sreader/config"
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html"
+)
+
+// Returned by Discover when there is no feed at or linked from a page
+var ErrNoFeeds = errors.New("no feeds found")
+
+// A feed found by Discover
+type DiscoveredFeed struct {
+	URL   string
+	Title string
+	Type  string // "rss", "atom" or "json"
+}
+
+// Link types of feeds in <link rel="alternate"> tags
+var feedLinkTypes = map[string]string{
+	"application/rss+xml":   "rss",
+	"application/rdf+xml":   "rss",
+	"application/atom+xml":  "atom",
+	"application/feed+json": "json",
+}
+
+// Paths where sites without feed links often have their feed
+var commonFeedPaths = []string{
+	"/feed",
+	"/rss",
+	"/feed.xml",
+	"/rss.xml",
+	"/atom.xml",
+	"/index.xml",
+	"/feed.json",
+}
+
+// Find the feeds at pageURL. If pageURL is a feed, returns just that feed.
+// If it is a web page, returns the feeds it links to with <link
+// rel="alternate">, or failing that, the feeds at common paths on its site.
+func Discover(pageURL string) ([]*DiscoveredFeed, error) {
+	clients := newHTTPClients()
+	defer saveCookies()
+
+	page, err := download(clients, pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if feed := detectFeed(page.feedURL, page.body); feed != nil {
+		return []*DiscoveredFeed{feed}, nil
+	}
+	if !isHTML(page.contentType, page.body) {
+		return nil, ErrNoFeeds
+	}
+
+	feeds := feedLinks(page.body, page.url)
+	if len(feeds) > 0 {
+		return feeds, nil
+	}
+
+	// Look for feeds where they usually are
+	for _, path := range commonFeedPaths {
+		candidate, err := download(clients, page.url.ResolveReference(&url.URL{Path: path}).String())
+		if err != nil {
+			continue
+		}
+		if feed := detectFeed(candidate.feedURL, candidate.body); feed != nil {
+			log.Println("Found feed at", feed.URL)
+			feeds = append(feeds, feed)
+		}
+	}
+	if len(feeds) == 0 {
+		return nil, ErrNoFeeds
+	}
+	return feeds, nil
+}
+
+// A downloaded page or feed
+type page struct {
+	body        []byte
+	url         *url.URL // URL the page came from, after redirects
+	feedURL     string   // URL to subscribe to, after permanent redirects
+	contentType string
+}
+
+// GET rawURL
+func download(clients *httpClients, rawURL string) (*page, error) {
+	req, err := newRequest(context.Background(), rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := clients.get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", rawURL, resp.Status)
+	}
+
+	body, err := io.ReadAll(newLimitedReader(resp.Body, int64(config.Config.MaxFeedSize)<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &page{body: body, url: resp.Request.URL, feedURL: rawURL, contentType: resp.Header.Get("Content-Type")}
+	if moved := permanentRedirect(resp); moved != "" && !keepURL(rawURL, moved) {
+		p.feedURL = moved
+	}
+	return p, nil
+}
+
+// Parse body as a feed fetched from feedURL. Returns nil if it is not one.
+func detectFeed(feedURL string, body []byte) *DiscoveredFeed {
+	var feedType string
+	switch gofeed.DetectFeedType(bytes.NewReader(body)) {
+	case gofeed.FeedTypeRSS:
+		feedType = "rss"
+	case gofeed.FeedTypeAtom:
+		feedType = "atom"
+	case gofeed.FeedTypeJSON:
+		feedType = "json"
+	default:
+		return nil
+	}
+
+	parsed, err := parseFeed(bytes.NewReader(body), feedURL)
+	if err != nil {
+		return nil
+	}
+	return &DiscoveredFeed{URL: feedURL, Title: parsed.Title, Type: feedType}
+}
+
+// Whether a response is an HTML page
+func isHTML(contentType string, body []byte) bool {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+	}
+	return strings.HasPrefix(http.DetectContentType(body), "text/html")
+}
+
+// Get the feeds an HTML page links to with <link rel="alternate">, resolving
+// their URLs against the page's URL (or its <base>)
+func feedLinks(page []byte, pageURL *url.URL) []*DiscoveredFeed {
+	var feeds []*DiscoveredFeed
+	seen := make(map[string]bool)
+	base := pageURL
+
+	z := html.NewTokenizer(bytes.NewReader(page))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return feeds
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		name, hasAttr := z.TagName()
+		if !hasAttr || (string(name) != "link" && string(name) != "base") {
+			continue
+		}
+
+		attrs := make(map[string]string)
+		for more := true; more; {
+			var key, val []byte
+			key, val, more = z.TagAttr()
+			attrs[string(key)] = string(val)
+		}
+
+		href, err := base.Parse(strings.TrimSpace(attrs["href"]))
+		if err != nil || attrs["href"] == "" {
+			continue
+		}
+		if string(name) == "base" {
+			base = href
+			continue
+		}
+
+		feedType, ok := feedLinkTypes[strings.ToLower(strings.TrimSpace(attrs["type"]))]
+		if !ok || !hasToken(attrs["rel"], "alternate") || seen[href.String()] {
+			continue
+		}
+		seen[href.String()] = true
+		feeds = append(feeds, &DiscoveredFeed{
+			URL:   href.String(),
+			Title: strings.TrimSpace(attrs["title"]),
+			Type:  feedType,
+		})
+	}
+}
+
+// Whether a space-separated list of tokens, like a rel attribute, has token
+func hasToken(list, token string) bool {
+	for _, t := range strings.Fields(list) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package feed
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	testRSS = `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>RSS Feed</title><link>https://example.com/</link>
+<item><title>Item</title><guid>1</guid></item></channel></rss>`
+	testAtom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom"><title>Atom Feed</title><id>urn:test</id>
+<updated>2024-01-01T00:00:00Z</updated></feed>`
+)
+
+// A response served by a test site
+type testResponse struct {
+	contentType string
+	body        string
+}
+
+// Start a server with responses by path. Other paths are not found.
+func newTestSite(t *testing.T, responses map[string]testResponse) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", resp.contentType)
+		w.Write([]byte(resp.body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDiscover(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		responses map[string]testResponse
+		path      string
+		want      []DiscoveredFeed // URLs relative to the site
+	}{
+		{
+			name: "links with base",
+			responses: map[string]testResponse{
+				"/blog/post": {"text/html", `<html><head><base href="/blog/">
+					<link rel="alternate" type="application/rss+xml" title="Posts" href="rss.xml">
+					<link rel="Alternate Feed" type="application/atom+xml" title="Comments" href="/comments.atom">
+					<link rel="stylesheet" type="text/css" href="style.css">
+					</head></html>`},
+			},
+			path: "/blog/post",
+			want: []DiscoveredFeed{
+				{URL: "/blog/rss.xml", Title: "Posts", Type: "rss"},
+				{URL: "/comments.atom", Title: "Comments", Type: "atom"},
+			},
+		},
+		{
+			name: "common paths",
+			responses: map[string]testResponse{
+				"/":         {"text/html", "<html><head><title>No links</title></head></html>"},
+				"/feed":     {"text/html", "<html>Not a feed</html>"},
+				"/rss.xml":  {"application/rss+xml", testRSS},
+				"/atom.xml": {"application/atom+xml", testAtom},
+			},
+			path: "/",
+			want: []DiscoveredFeed{
+				{URL: "/rss.xml", Title: "RSS Feed", Type: "rss"},
+				{URL: "/atom.xml", Title: "Atom Feed", Type: "atom"},
+			},
+		},
+		{
+			name: "feed",
+			responses: map[string]testResponse{
+				"/feed.xml": {"text/xml", testRSS},
+			},
+			path: "/feed.xml",
+			want: []DiscoveredFeed{{URL: "/feed.xml", Title: "RSS Feed", Type: "rss"}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			site := newTestSite(t, test.responses)
+			feeds, err := Discover(site.URL + test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(feeds) != len(test.want) {
+				t.Fatalf("found %d feeds, want %d", len(feeds), len(test.want))
+			}
+			for i, want := range test.want {
+				want.URL = site.URL + want.URL
+				if *feeds[i] != want {
+					t.Errorf("feed %d is %+v, want %+v", i, *feeds[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestDiscoverNoFeeds(t *testing.T) {
+	site := newTestSite(t, map[string]testResponse{
+		"/": {"text/html", `<html><head><link rel="alternate" type="text/html" href="/other"></head></html>`},
+	})
+	if _, err := Discover(site.URL + "/"); !errors.Is(err, ErrNoFeeds) {
+		t.Errorf("got error %v, want ErrNoFeeds", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"html"
 	"io"
 	"log"
@@ -11,12 +12,16 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/bmoneill/sreader/config"
 	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/rss"
 )
 
 // Open URL in web browser
@@ -32,174 +37,453 @@ func OpenInPlayer(url string, player string) {
 }
 
 // Sync feeds
-// This function asynchronously GETs feeds, using the last_updated field
-// in the database to only grab/update feeds that were updated since the last sync.
+// This function asynchronously GETs the subscribed feeds that are due (see
+// schedule), or all of them if force is set, skipping paused and gone feeds.
+// It uses conditional requests with each feed's stored ETag and Last-Modified
+// so that unchanged feeds are not downloaded again.
+// Feeds that moved permanently are stored under their new URL.
 // The new feed contents are then stored in the database.
-func Sync() {
-	ctx, cancel := context.WithCancel(context.Background())
+// Only one sync runs at a time: if another one is running, Sync waits for it
+// if WaitForSync is set and returns ErrSyncRunning otherwise.
+// Cancelling ctx, or an interrupt signal, stops the sync early; feeds not
+// synced by then are left for the next one.
+func Sync(ctx context.Context, s Store, force bool) error {
+	unlock, err := lockSync(s)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	feeds, err := s.ListFeeds()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	subscribed := len(feeds)
+	feeds = slices.DeleteFunc(feeds, func(f *Feed) bool {
+		return f.Paused || f.Gone || (!force && !due(f, now))
+	})
+	log.Println(len(feeds), "of", subscribed, "feeds due.")
+	if err := syncFeeds(ctx, s, feeds); err != nil {
+		log.Println("Sync cancelled.")
+		return err
+	}
+
+	if err := Prune(s); err != nil {
+		log.Println("Error pruning entries:", err.Error())
+	}
+	log.Println("Done.")
+	return nil
+}
+
+// Sync a single feed, e.g. one just subscribed to, even if it is paused or gone.
+// Waits for or skips a running sync and stops when ctx is cancelled, like Sync.
+func SyncFeed(ctx context.Context, s Store, url string) error {
+	unlock, err := lockSync(s)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	feed, err := s.GetFeedByURL(url)
+	if err != nil {
+		return err
+	}
+	if feed == nil {
+		feed = &Feed{URL: url}
+	}
+	return syncFeeds(ctx, s, []*Feed{feed})
+}
+
+// Take the store's sync lock, waiting for it if WaitForSync is set
+func lockSync(s Store) (func(), error) {
+	unlock, err := s.LockSync(false)
+	if err == ErrSyncRunning && config.Config.WaitForSync {
+		log.Println("Another sync is running, waiting for it to finish...")
+		unlock, err = s.LockSync(true)
+	}
+	return unlock, err
+}
+
+// Fetch feeds and store their contents. Returns the context's error if ctx
+// was cancelled or an interrupt signal stopped the sync.
+func syncFeeds(ctx context.Context, s Store, feeds []*Feed) error {
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	var wg sync.WaitGroup
 
 	// Listen for OS signals to gracefully shut down
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
 
-	// Start workers
-	log.Println("Getting feeds...")
-	for i := range config.Config.URLs {
-		url := config.Config.URLs[i]
-		if url == nil || len(strings.TrimSpace(*url)) < 1 {
-			continue
+	go func() {
+		select {
+		case <-sigChan:
+			cancel() // Cancel context when signal received
+		case <-ctx.Done():
 		}
+	}()
+
+	// Start a bounded pool of workers, limited per host. Each worker parses
+	// what it fetches and hands it to this goroutine, the only one writing to
+	// the store, so feeds are stored as soon as they arrive.
+	log.Println("Getting feeds...")
+	clients := newHTTPClients()
+	limiter := newHostLimiter(config.Config.HostWorkers, config.Config.HostRate)
+	jobs := make(chan *Feed)
 
-		feed := GetFeedByURL(*url)
+	workers := config.Config.Workers
+	if workers <= 0 || workers > len(feeds) {
+		workers = len(feeds)
+	}
+	results := make(chan *fetchResult, workers)
+	for range workers {
 		wg.Add(1)
-		if feed != nil {
-			go syncWorker(*url, feed.LastUpdated, &wg, ctx)
-		} else {
-			go syncWorker(*url, "", &wg, ctx)
-		}
+		go syncWorker(ctx, clients, limiter, jobs, results, &wg)
 	}
 
 	go func() {
-		<-sigChan
-		cancel() // Cancel context when signal received
+		for _, feed := range interleaveByHost(feeds) {
+			jobs <- feed
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
 	}()
 
-	wg.Wait()
+	for result := range results {
+		if result.err == nil && result.movedTo != "" {
+			result.err = moveFeed(s, result)
+		}
+		if result.err == nil && result.parsed != nil {
+			result.err = storeFetched(s, result)
+		}
+		recordSync(s, result.feed, result)
+	}
+	saveCookies()
+	return ctx.Err()
+}
 
-	log.Println("Updating DB...")
-	feed_contents := loadRSSFeeds()
-	for _, f := range feed_contents {
-		if f != nil {
-			if id, err := AddFeed(f); err != nil {
-				log.Println("Error adding feed:", err.Error())
-			} else {
-				MarkUpdated(id)
-			}
+// Move a feed that was permanently redirected to its new URL
+func moveFeed(s Store, result *fetchResult) error {
+	if result.feed.ID != 0 {
+		id, err := s.MoveFeed(result.feed.ID, result.movedTo)
+		if err != nil {
+			log.Println("Error moving feed:", err.Error())
+			return err
 		}
+		result.feed.ID = id
 	}
-	log.Println("Done.")
+	result.feed.URL = result.movedTo
+	return nil
 }
 
-// Unescape HTML entities and convert to ASCII
-func formatHTMLString(s string) string {
-	s = html.UnescapeString(s)
+// Store a fetched and parsed feed
+func storeFetched(s Store, result *fetchResult) error {
+	id, err := saveFeed(s, result.parsed, result)
+	if err != nil {
+		log.Println("Error adding feed:", err.Error())
+		return err
+	}
+	result.feed.ID = id
+	return nil
+}
 
-	ascii := make([]rune, 0, len(s))
-	for _, r := range s {
-		if r < 128 {
-			ascii = append(ascii, r)
-		} else {
-			ascii = append(ascii, ' ')
+// Record the outcome of syncing a feed
+func recordSync(s Store, feed *Feed, result *fetchResult) {
+	// Interrupted syncs say nothing about the feed
+	if feed.ID == 0 || errors.Is(result.err, context.Canceled) {
+		return
+	}
+
+	var err error
+	if result.err != nil {
+		err = s.RecordFailure(feed.ID, result.status, result.err.Error())
+	} else {
+		if result.status == http.StatusNotModified {
+			log.Println(feed.URL, "not modified.")
 		}
+		err = s.RecordSuccess(feed.ID, result.status)
+	}
+	if err == nil {
+		next, newest, unchanged := schedule(feed, result, time.Now())
+		err = s.SetSchedule(feed.ID, next, newest, unchanged)
+	}
+	if err != nil {
+		log.Println("Error recording sync status:", err.Error())
 	}
-	return string(ascii)
 }
 
-// Called by loadRSSFeeds. Parse feed from temporary file grabbed by syncWorkers and remove the file.
-func loadRSSFeed(url string) *gofeed.Feed {
-	filename := getTmpFilename(url)
-	file, err := os.Open(filename)
+// Store a fetched feed and its entries, along with the cache validators of
+// the response it came in.
+// If the feed already exists, it adds any new entries and updates existing ones.
+func saveFeed(s Store, feed *gofeed.Feed, fetch *fetchResult) (int64, error) {
+	fetched := time.Now()
+	markUnread := config.Config.MarkEditedUnread(feed.FeedLink)
+	updates := make([]EntryUpdate, len(feed.Items))
+	for i, item := range feed.Items {
+		entry, dated := newEntry(0, item, fetched)
+		updates[i] = EntryUpdate{Entry: entry, Dated: dated, MarkUnread: markUnread}
+	}
+
+	f := newFeed(feed)
+	f.ETag, f.LastModified = fetch.etag, fetch.lastModified
+	id, err := s.UpdateFeed(f, updates)
 	if err != nil {
-		log.Println("Failed to open temporary file:", err.Error())
-		return nil
+		return 0, err
 	}
 
-	fp := gofeed.NewParser()
-	feed, err := fp.Parse(file)
+	log.Println(feed.Title, "added/updated successfully,", len(feed.Items), "entries.")
+	return id, nil
+}
 
-	if err != nil {
-		log.Println("Failed to parse feed (possibly wrong URL or badly formatted XML?)")
-		return nil
+// Convert a parsed feed to a Feed without entries.
+// feed.FeedLink must be the URL the feed was fetched from.
+func newFeed(feed *gofeed.Feed) *Feed {
+	f := &Feed{
+		URL:         feed.FeedLink,
+		SiteURL:     feed.Link,
+		Title:       feed.Title,
+		Description: feed.Description,
+		Language:    feed.Language,
+		Generator:   feed.Generator,
 	}
 
-	// Unescape HTML entities and convert to ASCII
-	feed.Description = formatHTMLString(feed.Description)
-	feed.Title = formatHTMLString(feed.Title)
+	var authors []string
+	for _, author := range feed.Authors {
+		if author != nil && author.Name != "" {
+			authors = append(authors, author.Name)
+		} else if author != nil && author.Email != "" {
+			authors = append(authors, author.Email)
+		}
+	}
+	f.Author = strings.Join(authors, ", ")
 
-	// Set feed link to the URL used to fetch it
-	feed.Link = url
+	if feed.Image != nil {
+		f.Image = feed.Image.URL
+	}
 
-	for _, item := range feed.Items {
-		item.Title = formatHTMLString(item.Title)
-		item.Description = formatHTMLString(item.Description)
-		item.Content = formatHTMLString(item.Content)
+	if ttl, err := strconv.Atoi(strings.TrimSpace(feed.Custom[ttlKey])); err == nil && ttl > 0 {
+		f.TTL = ttl
+	}
+
+	for _, hour := range strings.Split(feed.Custom[skipHoursKey], ",") {
+		// Some feeds count hours from 1 to 24
+		if h, err := strconv.Atoi(strings.TrimSpace(hour)); err == nil && h >= 0 && h <= 24 {
+			f.SkipHours = append(f.SkipHours, h%24)
+		}
+	}
+	for _, day := range strings.Split(feed.Custom[skipDaysKey], ",") {
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if strings.EqualFold(strings.TrimSpace(day), d.String()) {
+				f.SkipDays = append(f.SkipDays, d)
+			}
+		}
 	}
 
-	os.Remove(filename) // Clean up temporary file
-	return feed
+	return f
 }
 
-// Parse all downloaded RSS feeds
-func loadRSSFeeds() []*gofeed.Feed {
-	var feeds []*gofeed.Feed
+// Keys of RSS <ttl>, <skipHours> and <skipDays> values in gofeed.Feed.Custom.
+// Hours and days are comma-separated.
+const (
+	ttlKey       = "ttl"
+	skipHoursKey = "skipHours"
+	skipDaysKey  = "skipDays"
+)
+
+// Translates RSS feeds like gofeed's default translator, but also keeps the
+// channel's <ttl>, <skipHours> and <skipDays> in Feed.Custom; the universal
+// feed type has no fields for them.
+type rssTranslator struct {
+	gofeed.DefaultRSSTranslator
+}
 
-	for _, url := range config.Config.URLs {
-		if len(*url) > 0 {
-			feeds = append(feeds, loadRSSFeed(*url))
+func (t *rssTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	f, err := t.DefaultRSSTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+
+	rssFeed, ok := feed.(*rss.Feed)
+	if !ok {
+		return f, nil
+	}
+
+	custom := map[string]string{
+		ttlKey:       rssFeed.TTL,
+		skipHoursKey: strings.Join(rssFeed.SkipHours, ","),
+		skipDaysKey:  strings.Join(rssFeed.SkipDays, ","),
+	}
+	for key, value := range custom {
+		if value == "" {
+			continue
 		}
+		if f.Custom == nil {
+			f.Custom = make(map[string]string)
+		}
+		f.Custom[key] = value
 	}
+	return f, nil
+}
 
-	return feeds
+// Get a feed parser using rssTranslator
+func newParser() *gofeed.Parser {
+	fp := gofeed.NewParser()
+	fp.RSSTranslator = &rssTranslator{}
+	return fp
 }
 
-// Single GET request worker
-func syncWorker(url string, modTime string, wg *sync.WaitGroup, ctx context.Context) {
-	defer wg.Done()
+// Convert a parsed feed item to an Entry belonging to feedID.
+// Returns false if the item carries no date, in which case the entry is dated
+// with the fetch time.
+func newEntry(feedID int64, item *gofeed.Item, fetched time.Time) (*Entry, bool) {
+	published, updated, dated := itemTimes(item, fetched)
+	entry := &Entry{
+		FeedID:      feedID,
+		GUID:        entryGUID(item),
+		URL:         item.Link,
+		Title:       item.Title,
+		Description: item.Description,
+		Content:     item.Content,
+		Published:   published,
+		Updated:     updated,
+		LastSeen:    fetched.UTC(),
+		Categories:  item.Categories,
+	}
 
-	// Get file name for URL
-	filename := getTmpFilename(url)
+	if item.Image != nil {
+		entry.Image = item.Image.URL
+	}
 
-	// Create request to fetch the feed
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		log.Println("Failed to create request for URL:", url, "Error:", err)
-		return
+	for _, author := range item.Authors {
+		if author != nil && (author.Name != "" || author.Email != "") {
+			entry.Authors = append(entry.Authors, Person{Name: author.Name, Email: author.Email})
+		}
 	}
 
-	// HTTP headers
-	req.Header.Set("User-Agent", "sreader/1.0")
-	if modTime != "" {
-		req.Header.Set("If-Modified-Since", modTime)
+	for _, enclosure := range item.Enclosures {
+		if enclosure == nil || enclosure.URL == "" {
+			continue
+		}
+		length, _ := strconv.ParseInt(enclosure.Length, 10, 64)
+		entry.Enclosures = append(entry.Enclosures, Enclosure{
+			URL:    enclosure.URL,
+			Type:   enclosure.Type,
+			Length: length,
+		})
 	}
 
-	// Do GET request
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Fatalln("Failed to fetch feed:", url, "Error:", err)
+	return entry, dated
+}
+
+// Get the identity of a feed item: its GUID, falling back to its link and
+// then to a hash of its contents.
+func entryGUID(item *gofeed.Item) string {
+	if item.GUID != "" {
+		return item.GUID
+	}
+	if item.Link != "" {
+		return item.Link
 	}
+	return contentHash(item.Title, item.Description, item.Content)
+}
 
-	// Create the temporary file
-	out, err := os.Create(filename)
-	if err != nil {
-		log.Fatalln("Failed to create temporary file:", err)
+// Hash the text of an entry that has neither a GUID nor a link
+func contentHash(title, description, content string) string {
+	sum := sha1.Sum([]byte(title + "\n" + description + "\n" + content))
+	return "sha1:" + hex.EncodeToString(sum[:])
+}
+
+// Unescape HTML entities and convert to ASCII
+func formatHTMLString(s string) string {
+	s = html.UnescapeString(s)
+
+	ascii := make([]rune, 0, len(s))
+	for _, r := range s {
+		if r < 128 {
+			ascii = append(ascii, r)
+		} else {
+			ascii = append(ascii, ' ')
+		}
 	}
+	return string(ascii)
+}
 
-	// Copy response body to the temporary file
-	if resp.StatusCode == http.StatusOK {
-		_, err = io.Copy(out, resp.Body)
-		if err != nil {
-			panic(err)
+// Layouts tried when parsing dates gofeed could not, e.g. dc:date in Atom feeds
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC822Z,
+	time.RFC822,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// Parse a date string in any of dateLayouts
+func parseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
 		}
-	} else if resp.StatusCode != http.StatusNotModified {
-		log.Println("Failed to download feed \"" + url + "\": " + resp.Status)
 	}
+	return time.Time{}, false
+}
 
-	out.Close()
+// Get the publication and update times of a feed item.
+// The publication time falls back to the updated date, then dc:date, then the
+// time the feed was fetched; the last case returns dated = false.
+func itemTimes(item *gofeed.Item, fetched time.Time) (published, updated time.Time, dated bool) {
+	if item.UpdatedParsed != nil {
+		updated = item.UpdatedParsed.UTC()
+	}
 
-	select {
-	case <-ctx.Done():
-		// Context was cancelled, clean up and exit
-		log.Println("Sync cancelled for URL:", url)
-		os.Remove(filename)
-		return
-	default:
-		return
+	switch {
+	case item.PublishedParsed != nil:
+		published = item.PublishedParsed.UTC()
+	case item.UpdatedParsed != nil:
+		published = updated
+	case item.DublinCoreExt != nil:
+		for _, date := range item.DublinCoreExt.Date {
+			if t, ok := parseDate(date); ok {
+				published = t.UTC()
+				break
+			}
+		}
 	}
+
+	if published.IsZero() {
+		return fetched.UTC(), updated, false
+	}
+	return published, updated, true
 }
 
-func getTmpFilename(url string) string {
-	urlsum := sha1.Sum([]byte(url))
-	return config.Config.TmpDir + "/" + hex.EncodeToString(urlsum[:]) + ".tmp"
+// Parse a feed fetched from url
+func parseFeed(r io.Reader, url string) (*gofeed.Feed, error) {
+	fp := newParser()
+	feed, err := fp.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unescape HTML entities and convert to ASCII
+	feed.Description = formatHTMLString(feed.Description)
+	feed.Title = formatHTMLString(feed.Title)
+
+	// Set feed link to the URL used to fetch it, keeping the site link
+	feed.FeedLink = url
+
+	for _, item := range feed.Items {
+		item.Title = formatHTMLString(item.Title)
+		item.Description = formatHTMLString(item.Description)
+		item.Content = formatHTMLString(item.Content)
+	}
+
+	return feed, nil
 }
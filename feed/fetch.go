@@ -0,0 +1,320 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bmoneill/sreader/config"
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	// Longest wait between retries, whatever the backoff or Retry-After says.
+	// A server asking for a longer wait is not retried until the next sync.
+	maxRetryWait = 2 * time.Minute
+)
+
+// Returned when a feed is larger than config.Config.MaxFeedSize
+var errFeedTooLarge = errors.New("feed is too large")
+
+// Returned when a feed URL leads to a web page instead of a feed
+var errWebPage = errors.New("URL is a web page, not a feed; subscribe to the page again to find its feeds")
+
+// Outcome of fetching a feed
+type fetchResult struct {
+	feed         *Feed
+	parsed       *gofeed.Feed // The feed if it changed
+	err          error        // Why the sync failed, if it did
+	status       int          // HTTP status, 0 if the request failed
+	etag         string       // ETag response header
+	lastModified string       // Last-Modified response header
+	movedTo      string       // URL the feed moved to permanently, if it did
+	retryAfter   time.Duration
+	maxAge       time.Duration // How long the response may be cached, from Cache-Control
+}
+
+// Fetch each feed received from jobs and send the results, until jobs is closed
+func syncWorker(ctx context.Context, clients *httpClients, limiter *hostLimiter,
+	jobs <-chan *Feed, results chan<- *fetchResult, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for feed := range jobs {
+		result := &fetchResult{feed: feed}
+		fetchWithRetries(ctx, clients, limiter, result)
+		results <- result
+	}
+}
+
+// Fetch and parse result.feed. Transient failures are retried up to
+// config.Config.Retries times.
+func fetchWithRetries(ctx context.Context, clients *httpClients, limiter *hostLimiter, result *fetchResult) {
+	feed := result.feed
+	for attempt := 0; ; attempt++ {
+		release, err := limiter.acquire(ctx, feed.URL)
+		if err != nil {
+			result.err = err
+			return
+		}
+		*result = fetch(ctx, clients, feed)
+		release()
+
+		if result.err == nil || attempt >= config.Config.Retries || !isTransient(result) {
+			return
+		}
+
+		wait := backoff(attempt)
+		if result.retryAfter > 0 {
+			wait = result.retryAfter
+		}
+		if wait > maxRetryWait {
+			return
+		}
+
+		log.Println("Retrying", feed.URL, "in", wait.Round(time.Millisecond), "after error:", result.err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// GET and parse a feed once
+func fetch(ctx context.Context, clients *httpClients, feed *Feed) fetchResult {
+	result := fetchResult{feed: feed}
+	url := feed.URL
+
+	// Create request to fetch the feed
+	req, err := newRequest(ctx, url)
+	if err != nil {
+		log.Println("Failed to create request for URL:", url, "Error:", err)
+		result.err = err
+		return result
+	}
+
+	// Conditional requests replay the validators the server sent with the
+	// last stored version of the feed
+	if feed.ETag != "" {
+		req.Header.Set("If-None-Match", feed.ETag)
+	}
+	if feed.LastModified != "" {
+		req.Header.Set("If-Modified-Since", feed.LastModified)
+	}
+
+	client, err := clients.get(url)
+	if err != nil {
+		log.Println("Failed to set up connection for URL:", url, "Error:", err)
+		result.err = err
+		return result
+	}
+
+	// Do GET request
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Println("Failed to fetch feed:", url, "Error:", err)
+		result.err = err
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.status = resp.StatusCode
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNotModified:
+		result.maxAge = parseMaxAge(resp.Header.Get("Cache-Control"))
+
+		// Only a feed that is still there has moved
+		if moved := permanentRedirect(resp); moved != "" && !keepURL(url, moved) {
+			log.Println(url, "moved permanently to", moved)
+			result.movedTo = moved
+			url = moved
+		}
+	case http.StatusGone:
+		log.Println("Feed \"" + url + "\" is gone and will no longer be synced")
+		result.err = errors.New(resp.Status)
+		return result
+	default:
+		log.Println("Failed to download feed \"" + url + "\": " + resp.Status)
+		result.err = errors.New(resp.Status)
+		result.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		return result
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		return result
+	}
+
+	// Parse the response as it arrives
+	body := newLimitedReader(resp.Body, int64(config.Config.MaxFeedSize)<<20)
+	parsed, err := parseFeed(body, url)
+	switch {
+	case ctx.Err() != nil:
+		log.Println("Sync cancelled for URL:", url)
+		result.err = ctx.Err()
+	case body.err != nil:
+		// Errors reading the body take precedence over the parse errors they cause
+		log.Println("Failed to download feed:", url, "Error:", body.err)
+		result.err = fmt.Errorf("failed to download feed: %w", body.err)
+	case err != nil && isHTML(resp.Header.Get("Content-Type"), nil):
+		log.Println("Failed to parse feed:", url, "is a web page")
+		result.err = errWebPage
+	case err != nil:
+		log.Println("Failed to parse feed (possibly wrong URL or badly formatted XML?)")
+		result.err = fmt.Errorf("failed to parse feed: %w", err)
+	default:
+		result.parsed = parsed
+		result.etag = resp.Header.Get("ETag")
+		result.lastModified = resp.Header.Get("Last-Modified")
+	}
+	return result
+}
+
+// Report whether a feed that moved from url to movedTo must keep its URL.
+// Settings are looked up by URL, so a feed with settings of its own stays at
+// url until they are moved to the new URL in the configuration.
+func keepURL(url, movedTo string) bool {
+	if config.Config.Feeds[url] == nil {
+		return false
+	}
+	log.Println(url, "moved permanently to", movedTo+"; move its settings to the new URL to follow it")
+	return true
+}
+
+// Get the URL a response came from if it was reached by permanent redirects
+// (301 or 308) only. Following a temporary redirect, returns the URL of the
+// last permanent one before it. Returns "" if there was no permanent redirect.
+func permanentRedirect(resp *http.Response) string {
+	// Walk back from the last request to the first
+	var chain []*http.Request
+	for req := resp.Request; req != nil; {
+		chain = append([]*http.Request{req}, chain...)
+		if req.Response == nil {
+			break
+		}
+		req = req.Response.Request
+	}
+
+	moved := ""
+	for _, req := range chain[1:] {
+		if status := req.Response.StatusCode; status != http.StatusMovedPermanently && status != http.StatusPermanentRedirect {
+			break
+		}
+		moved = req.URL.String()
+	}
+	if moved == chain[0].URL.String() {
+		return ""
+	}
+	return moved
+}
+
+// Reader that fails with errFeedTooLarge after reading more than limit bytes
+// (if limit > 0) and keeps the first error other than io.EOF
+type limitedReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+	err   error
+}
+
+func newLimitedReader(r io.Reader, limit int64) *limitedReader {
+	if limit > 0 {
+		// One byte over the limit is enough to know it was exceeded
+		r = io.LimitReader(r, limit+1)
+	}
+	return &limitedReader{r: r, limit: limit}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.limit > 0 && l.read > l.limit {
+		err = errFeedTooLarge
+	}
+	if err != nil && err != io.EOF {
+		l.err = err
+	}
+	return n, err
+}
+
+// Whether a failed fetch may succeed if retried: timeouts, dropped
+// connections, server errors and rate limiting
+func isTransient(result *fetchResult) bool {
+	if errors.Is(result.err, context.Canceled) {
+		return false
+	}
+
+	switch {
+	case result.status == http.StatusTooManyRequests,
+		result.status == http.StatusRequestTimeout,
+		result.status >= 500 && result.status != http.StatusNotImplemented:
+		return true
+	case result.status != 0 && result.status != http.StatusOK:
+		return false
+	}
+
+	var netErr net.Error
+	return (errors.As(result.err, &netErr) && netErr.Timeout()) ||
+		errors.Is(result.err, syscall.ECONNRESET) ||
+		errors.Is(result.err, syscall.ECONNREFUSED) ||
+		errors.Is(result.err, io.ErrUnexpectedEOF)
+}
+
+// Get the wait before retry number attempt+1: exponential backoff from
+// config.Config.RetryDelay with random jitter, so that feeds on the same host
+// are not all retried at once
+func backoff(attempt int) time.Duration {
+	base := time.Duration(config.Config.RetryDelay) * time.Second
+	if base <= 0 {
+		return 0
+	}
+
+	wait := maxRetryWait
+	if attempt < 16 && base<<attempt < maxRetryWait {
+		wait = base << attempt
+	}
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// Parse a Retry-After header, given either in seconds or as an HTTP date.
+// Returns 0 if it is missing or invalid.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// Parse the max-age directive of a Cache-Control header. Returns 0 if there
+// is none.
+func parseMaxAge(value string) time.Duration {
+	for _, directive := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		if seconds, err := strconv.Atoi(strings.Trim(arg, `"`)); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return 0
+}
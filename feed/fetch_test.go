@@ -0,0 +1,271 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bmoneill/sreader/config"
+)
+
+// Change the global configuration for the rest of the test
+func setConfig(t *testing.T, change func(c *config.SreaderConfig)) {
+	t.Helper()
+	saved := *config.Config
+	change(config.Config)
+	t.Cleanup(func() { *config.Config = saved })
+}
+
+// Start a server answering each request with respond, which gets the number
+// of the request, starting at 0
+func newCountingServer(t *testing.T, respond func(w http.ResponseWriter, r *http.Request, n int)) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respond(w, r, int(hits.Add(1))-1)
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func TestFetchRetries(t *testing.T) {
+	setConfig(t, func(c *config.SreaderConfig) {
+		c.Retries, c.RetryDelay, c.MaxFeedSize = 2, 0, 1
+	})
+	retryAt := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+
+	for _, test := range []struct {
+		name    string
+		respond func(w http.ResponseWriter, r *http.Request, n int)
+		hits    int
+		err     error // Expected error, nil for success
+	}{
+		{
+			name: "server error then success",
+			respond: func(w http.ResponseWriter, r *http.Request, n int) {
+				if n == 0 {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Write([]byte(testRSS))
+			},
+			hits: 2,
+		},
+		{
+			name: "rate limited beyond the retry budget",
+			respond: func(w http.ResponseWriter, r *http.Request, n int) {
+				w.Header().Set("Retry-After", retryAt)
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			hits: 1,
+			err:  errors.New("429 Too Many Requests"),
+		},
+		{
+			name: "not found",
+			respond: func(w http.ResponseWriter, r *http.Request, n int) {
+				http.NotFound(w, r)
+			},
+			hits: 1,
+			err:  errors.New("404 Not Found"),
+		},
+		{
+			name: "too large",
+			respond: func(w http.ResponseWriter, r *http.Request, n int) {
+				w.Write([]byte(`<?xml version="1.0"?><rss version="2.0"><channel><title>`))
+				w.Write([]byte(strings.Repeat("x", 2<<20)))
+			},
+			hits: 1,
+			err:  errFeedTooLarge,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server, hits := newCountingServer(t, test.respond)
+			result := &fetchResult{feed: &Feed{URL: server.URL + "/feed"}}
+			fetchWithRetries(context.Background(), newHTTPClients(), newHostLimiter(0, 0), result)
+
+			if n := int(hits.Load()); n != test.hits {
+				t.Errorf("fetched %d times, want %d", n, test.hits)
+			}
+			switch {
+			case test.err == nil && result.err != nil:
+				t.Errorf("got error %v", result.err)
+			case test.err == nil && result.parsed == nil:
+				t.Error("feed was not parsed")
+			case test.err != nil && (result.err == nil ||
+				!errors.Is(result.err, test.err) && result.err.Error() != test.err.Error()):
+				t.Errorf("got error %v, want %v", result.err, test.err)
+			}
+		})
+	}
+}
+
+func TestRetryAfterDelaysNextSync(t *testing.T) {
+	retryAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	server, _ := newCountingServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		w.Header().Set("Retry-After", retryAt.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	s := NewMemoryStore()
+	url := server.URL + "/feed"
+	if _, err := s.Subscribe(url); err != nil {
+		t.Fatal(err)
+	}
+	if err := SyncFeed(context.Background(), s, url); err != nil {
+		t.Fatal(err)
+	}
+
+	feed, err := s.GetFeedByURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.HTTPStatus != http.StatusTooManyRequests || feed.Failures != 1 {
+		t.Errorf("recorded status %d and %d failures, want 429 and 1", feed.HTTPStatus, feed.Failures)
+	}
+	if feed.NextSync.Before(retryAt.Add(-time.Second)) {
+		t.Errorf("next sync at %v, before the server's Retry-After of %v", feed.NextSync, retryAt)
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		redirects map[string]int // Status of redirects from /path to /path/next
+		settings  bool           // Whether the feed has settings of its own
+		want      string         // Path the feed ends up at
+	}{
+		{name: "moved permanently", redirects: map[string]int{"/old": 301}, want: "/old/next"},
+		{name: "found", redirects: map[string]int{"/old": 302}, want: "/old"},
+		{
+			name:      "moved permanently then found",
+			redirects: map[string]int{"/old": 301, "/old/next": 302},
+			want:      "/old/next",
+		},
+		{name: "settings", redirects: map[string]int{"/old": 301}, settings: true, want: "/old"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := newCountingServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+				if status, ok := test.redirects[r.URL.Path]; ok {
+					http.Redirect(w, r, r.URL.Path+"/next", status)
+					return
+				}
+				w.Write([]byte(testRSS))
+			})
+			url := server.URL + "/old"
+			if test.settings {
+				setFeedConfig(t, url, &config.FeedConfig{})
+			}
+
+			s := NewMemoryStore()
+			if _, err := s.Subscribe(url); err != nil {
+				t.Fatal(err)
+			}
+			if err := SyncFeed(context.Background(), s, url); err != nil {
+				t.Fatal(err)
+			}
+
+			feeds := getFeedURLs(t, s)
+			if len(feeds) != 1 || feeds[0] != server.URL+test.want {
+				t.Fatalf("feeds are at %v, want %s", feeds, test.want)
+			}
+			feed, err := s.GetFeedByURL(feeds[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(getEntries(t, s, feed.ID)); n != 1 {
+				t.Errorf("feed has %d entries, want 1", n)
+			}
+		})
+	}
+}
+
+func TestGone(t *testing.T) {
+	server, hits := newCountingServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		w.WriteHeader(http.StatusGone)
+	})
+	s := NewMemoryStore()
+	url := server.URL + "/feed"
+	if _, err := s.Subscribe(url); err != nil {
+		t.Fatal(err)
+	}
+	if err := SyncFeed(context.Background(), s, url); err != nil {
+		t.Fatal(err)
+	}
+
+	feed, err := s.GetFeedByURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !feed.Gone {
+		t.Fatal("feed is not marked gone")
+	}
+
+	// Even a forced sync leaves it alone
+	if err := Sync(context.Background(), s, true); err != nil {
+		t.Fatal(err)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("fetched %d times, want 1", n)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	setConfig(t, func(c *config.SreaderConfig) { c.Retries = 0 })
+	const etag, lastModified = `"v1"`, "Mon, 01 Jan 2024 00:00:00 GMT"
+
+	for name, s := range map[string]Store{"memory": NewMemoryStore(), "sqlite": openTestStore(t)} {
+		t.Run(name, func(t *testing.T) {
+			var validators []string
+			server, _ := newCountingServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+				validators = append(validators, r.Header.Get("If-None-Match")+"|"+r.Header.Get("If-Modified-Since"))
+				switch n {
+				case 0:
+					w.Header().Set("ETag", etag)
+					w.Header().Set("Last-Modified", lastModified)
+					w.Write([]byte(testRSS))
+				case 1:
+					w.WriteHeader(http.StatusInternalServerError)
+				default:
+					w.WriteHeader(http.StatusNotModified)
+				}
+			})
+			url := server.URL + "/feed"
+			if _, err := s.Subscribe(url); err != nil {
+				t.Fatal(err)
+			}
+			for range 3 {
+				if err := SyncFeed(context.Background(), s, url); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// The first request has nothing to validate, and a failure keeps
+			// the validators of the last stored response
+			want := []string{"|", etag + "|" + lastModified, etag + "|" + lastModified}
+			if !slices.Equal(validators, want) {
+				t.Errorf("sent validators %q, want %q", validators, want)
+			}
+
+			feed, err := s.GetFeedByURL(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if feed.HTTPStatus != http.StatusNotModified || feed.Failures != 0 {
+				t.Errorf("recorded status %d and %d failures, want 304 and 0", feed.HTTPStatus, feed.Failures)
+			}
+			if feed.ETag != etag || feed.LastModified != lastModified {
+				t.Errorf("stored validators %q and %q", feed.ETag, feed.LastModified)
+			}
+			if n := len(getEntries(t, s, feed.ID)); n != 1 {
+				t.Errorf("feed has %d entries after not being modified, want 1", n)
+			}
+		})
+	}
+}
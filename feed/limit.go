@@ -0,0 +1,111 @@
+package feed
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits the number and rate of concurrent requests to each host
+type hostLimiter struct {
+	mu          sync.Mutex
+	hosts       map[string]*hostState
+	concurrency int           // Concurrent requests per host, 0 for no limit
+	interval    time.Duration // Time between the starts of requests to a host
+}
+
+type hostState struct {
+	slots chan struct{} // Holds a value for each request in progress
+	next  time.Time     // Earliest start of the next request
+}
+
+func newHostLimiter(concurrency int, rate float64) *hostLimiter {
+	l := &hostLimiter{hosts: make(map[string]*hostState), concurrency: concurrency}
+	if rate > 0 {
+		l.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return l
+}
+
+// Wait until a request to the host of rawURL may start. Call the returned
+// function when the request is done.
+func (l *hostLimiter) acquire(ctx context.Context, rawURL string) (func(), error) {
+	host := hostOf(rawURL)
+
+	l.mu.Lock()
+	state := l.hosts[host]
+	if state == nil {
+		state = &hostState{}
+		if l.concurrency > 0 {
+			state.slots = make(chan struct{}, l.concurrency)
+		}
+		l.hosts[host] = state
+	}
+	l.mu.Unlock()
+
+	release := func() {}
+	if state.slots != nil {
+		select {
+		case state.slots <- struct{}{}:
+			release = func() { <-state.slots }
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// Reserve the next start time for this request
+	l.mu.Lock()
+	now := time.Now()
+	start := now
+	if state.next.After(now) {
+		start = state.next
+	}
+	state.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait := time.Until(start); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// Get the host a limit applies to for rawURL
+func hostOf(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return strings.ToLower(u.Host)
+	}
+	return rawURL
+}
+
+// Order feeds by taking one from each host in turn, keeping their order
+// within a host. Workers wait for a busy host, so a run of feeds on one host
+// would hold up every worker while feeds on other hosts could be fetched.
+func interleaveByHost(feeds []*Feed) []*Feed {
+	var hosts []string
+	byHost := make(map[string][]*Feed)
+	for _, f := range feeds {
+		host := hostOf(f.URL)
+		if byHost[host] == nil {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], f)
+	}
+
+	ordered := make([]*Feed, 0, len(feeds))
+	for len(ordered) < len(feeds) {
+		for _, host := range hosts {
+			if queue := byHost[host]; len(queue) > 0 {
+				ordered = append(ordered, queue[0])
+				byHost[host] = queue[1:]
+			}
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,30 @@
+package feed
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestInterleaveByHost(t *testing.T) {
+	var feeds []*Feed
+	for _, url := range []string{
+		"https://a.test/1", "https://a.test/2", "https://A.test/3",
+		"https://b.test/1", "https://b.test/2",
+		"https://c.test/1",
+	} {
+		feeds = append(feeds, &Feed{URL: url})
+	}
+
+	var got []string
+	for _, f := range interleaveByHost(feeds) {
+		got = append(got, f.URL)
+	}
+	want := []string{
+		"https://a.test/1", "https://b.test/1", "https://c.test/1",
+		"https://a.test/2", "https://b.test/2",
+		"https://A.test/3",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,42 @@
+package feed
+
+import (
+	"errors"
+	"sync"
+)
+
+// Returned by Store.LockSync when another sync holds the lock
+var ErrSyncRunning = errors.New("another sync is already running")
+
+// Take mu, or fail with ErrSyncRunning if it is held and wait is false
+func lockMutex(mu *sync.Mutex, wait bool) error {
+	if wait {
+		mu.Lock()
+	} else if !mu.TryLock() {
+		return ErrSyncRunning
+	}
+	return nil
+}
+
+// Take the sync lock of the database. The lock is a file next to the
+// database, so it also keeps other sreader processes (e.g. "sreader -s" run
+// from cron while the UI is open) from syncing at the same time.
+func (s *SQLiteStore) LockSync(wait bool) (func(), error) {
+	if err := lockMutex(&s.syncMu, wait); err != nil {
+		return nil, err
+	}
+
+	if s.path == ":memory:" {
+		return s.syncMu.Unlock, nil
+	}
+
+	unlockFile, err := lockFile(s.path+".lock", wait)
+	if err != nil {
+		s.syncMu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		s.syncMu.Unlock()
+	}, nil
+}
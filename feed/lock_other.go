@@ -0,0 +1,9 @@
+//go:build !unix
+
+package feed
+
+// File locks are not supported here, so syncs are only kept apart within
+// this process.
+func lockFile(path string, wait bool) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package feed
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Take an exclusive flock on the file at path, creating it if needed.
+// The lock is released if the process dies.
+func lockFile(path string, wait bool) (func(), error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	if err := syscall.Flock(int(file.Fd()), how); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrSyncRunning
+		}
+		return nil, err
+	}
+
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
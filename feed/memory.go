@@ -0,0 +1,524 @@
+package feed
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store that keeps feeds and entries in memory. Nothing is persisted.
+type MemoryStore struct {
+	mu        sync.Mutex
+	feeds     []*Feed // Without entries
+	entries   map[int64]*Entry
+	revisions map[int64][]*Revision // By entry ID, oldest first
+	removed   map[string]bool       // URLs unsubscribed from
+	moved     map[string]int64      // IDs of feeds by the URLs they moved from
+	nextID    int64
+	syncMu    sync.Mutex
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries:   make(map[int64]*Entry),
+		revisions: make(map[int64][]*Revision),
+		removed:   make(map[string]bool),
+		moved:     make(map[string]int64),
+	}
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) LockSync(wait bool) (func(), error) {
+	if err := lockMutex(&s.syncMu, wait); err != nil {
+		return nil, err
+	}
+	return s.syncMu.Unlock, nil
+}
+
+// Get a new feed or entry ID
+func (s *MemoryStore) newID() int64 {
+	s.nextID++
+	return s.nextID
+}
+
+func (s *MemoryStore) ListFeeds() ([]*Feed, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	feeds := make([]*Feed, len(s.feeds))
+	for i, f := range s.feeds {
+		feeds[i] = copyFeed(f)
+	}
+	return feeds, nil
+}
+
+func (s *MemoryStore) GetFeedByURL(url string) (*Feed, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, f := range s.feeds {
+		if f.URL == url {
+			feed := copyFeed(f)
+			feed.Entries = s.filterEntries(func(e *Entry) bool { return e.FeedID == f.ID })
+			return feed, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) AddFeed(feed *Feed) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addFeed(feed), nil
+}
+
+// Must hold s.mu
+func (s *MemoryStore) addFeed(feed *Feed) int64 {
+	for i, f := range s.feeds {
+		if f.URL == feed.URL {
+			updated := copyFeed(feed)
+			updated.ID, updated.CustomTitle, updated.Paused = f.ID, f.CustomTitle, f.Paused
+			updated.LastUpdated, updated.LastAttempt = f.LastUpdated, f.LastAttempt
+			updated.HTTPStatus, updated.Failures, updated.LastError = f.HTTPStatus, f.Failures, f.LastError
+			updated.Gone = f.Gone
+			updated.NextSync, updated.Newest, updated.Unchanged = f.NextSync, f.Newest, f.Unchanged
+			s.feeds[i] = updated
+			return f.ID
+		}
+	}
+
+	f := copyFeed(feed)
+	f.ID = s.newID()
+	s.feeds = append(s.feeds, f)
+	return f.ID
+}
+
+func (s *MemoryStore) Subscribe(url string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.removed, url)
+	id, _ := s.subscribe(url)
+	return id, nil
+}
+
+// Add a feed with only a URL unless it exists or moved from url. Returns the
+// feed's ID and whether it was added. Must hold s.mu.
+func (s *MemoryStore) subscribe(url string) (int64, bool) {
+	if f := s.feedByURL(url); f != nil {
+		return f.ID, false
+	}
+	if f := s.feedByID(s.moved[url]); f != nil {
+		return f.ID, false
+	}
+	return s.addFeed(&Feed{URL: url}), true
+}
+
+func (s *MemoryStore) Import(urls []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	added := 0
+	for _, url := range urls {
+		if s.removed[url] {
+			continue
+		}
+		if _, ok := s.subscribe(url); ok {
+			added++
+		}
+	}
+	return added, nil
+}
+
+func (s *MemoryStore) Unsubscribe(feedID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.feeds, func(f *Feed) bool { return f.ID == feedID })
+	if i < 0 {
+		return nil
+	}
+	s.removed[s.feeds[i].URL] = true
+	s.feeds = slices.Delete(s.feeds, i, i+1)
+	for url, id := range s.moved {
+		if id == feedID {
+			s.removed[url] = true
+			delete(s.moved, url)
+		}
+	}
+
+	for id, e := range s.entries {
+		if e.FeedID == feedID {
+			delete(s.entries, id)
+			delete(s.revisions, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RenameFeed(feedID int64, title string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f := s.feedByID(feedID); f != nil {
+		f.CustomTitle = title
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetPaused(feedID int64, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f := s.feedByID(feedID); f != nil {
+		f.Paused = paused
+	}
+	return nil
+}
+
+func (s *MemoryStore) MoveFeed(feedID int64, url string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := s.feedByID(feedID)
+	if f == nil {
+		return 0, nil
+	}
+	if f.URL == url {
+		return feedID, nil
+	}
+
+	oldURL := f.URL
+	target := s.feedByURL(url)
+	if target == nil {
+		f.URL = url
+		target = f
+	} else {
+		// Keep the entries the feed at url does not have, and the state of
+		// those it does
+		guids := make(map[string]*Entry)
+		for _, e := range s.entries {
+			if e.FeedID == target.ID {
+				guids[e.GUID] = e
+			}
+		}
+		for id, e := range s.entries {
+			if e.FeedID != feedID {
+				continue
+			}
+			if kept := guids[e.GUID]; kept != nil {
+				kept.Read = kept.Read || e.Read
+				kept.Starred = kept.Starred || e.Starred
+				delete(s.entries, id)
+				delete(s.revisions, id)
+			} else {
+				e.FeedID = target.ID
+			}
+		}
+		for u, id := range s.moved {
+			if id == feedID {
+				s.moved[u] = target.ID
+			}
+		}
+		s.feeds = slices.DeleteFunc(s.feeds, func(f *Feed) bool { return f.ID == feedID })
+	}
+
+	s.moved[oldURL] = target.ID
+	delete(s.moved, url)
+	return target.ID, nil
+}
+
+// Must hold s.mu
+func (s *MemoryStore) feedByURL(url string) *Feed {
+	for _, f := range s.feeds {
+		if f.URL == url {
+			return f
+		}
+	}
+	return nil
+}
+
+// Must hold s.mu
+func (s *MemoryStore) feedByID(id int64) *Feed {
+	for _, f := range s.feeds {
+		if f.ID == id {
+			return f
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RecordSuccess(feedID int64, status int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f := s.feedByID(feedID); f != nil {
+		now := time.Now().UTC()
+		f.LastUpdated, f.LastAttempt, f.HTTPStatus = now, now, status
+		f.Failures, f.LastError, f.Gone = 0, "", false
+	}
+	return nil
+}
+
+func (s *MemoryStore) RecordFailure(feedID int64, status int, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f := s.feedByID(feedID); f != nil {
+		f.LastAttempt, f.HTTPStatus = time.Now().UTC(), status
+		f.Failures++
+		f.LastError = message
+		f.Gone = status == http.StatusGone
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetSchedule(feedID int64, next, newest time.Time, unchanged int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f := s.feedByID(feedID); f != nil {
+		f.NextSync, f.Newest, f.Unchanged = next, newest, unchanged
+	}
+	return nil
+}
+
+func (s *MemoryStore) AddEntry(entry *Entry, dated bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addEntry(entry, dated, false)
+	return nil
+}
+
+func (s *MemoryStore) UpdateFeed(feed *Feed, updates []EntryUpdate) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.addFeed(feed)
+	for _, u := range updates {
+		u.Entry.FeedID = id
+		s.addEntry(u.Entry, u.Dated, u.MarkUnread)
+	}
+	return id, nil
+}
+
+// Must hold s.mu
+func (s *MemoryStore) addEntry(entry *Entry, dated, markUnread bool) {
+	stored := copyEntry(entry)
+	for _, e := range s.entries {
+		if e.FeedID == entry.FeedID && e.GUID == entry.GUID {
+			// Keep the state and, for undated entries, the publication time
+			stored.ID, stored.Read, stored.Starred, stored.Revisions = e.ID, e.Read, e.Starred, e.Revisions
+			if !dated {
+				stored.Published = e.Published
+			}
+
+			// Entries never seen by a sync have no comparable text
+			if !e.LastSeen.IsZero() && (e.Title != entry.Title || e.Description != entry.Description || e.Content != entry.Content) {
+				s.revisions[e.ID] = append(s.revisions[e.ID], &Revision{
+					ID:          s.newID(),
+					EntryID:     e.ID,
+					Title:       e.Title,
+					Description: e.Description,
+					Content:     e.Content,
+					Updated:     e.Updated,
+					Replaced:    entry.LastSeen,
+				})
+				stored.Revisions++
+				if markUnread {
+					stored.Read = false
+				}
+			}
+			break
+		}
+	}
+
+	if stored.ID == 0 {
+		stored.ID = s.newID()
+	}
+	s.entries[stored.ID] = stored
+	entry.ID = stored.ID
+}
+
+func (s *MemoryStore) GetEntries(feedID int64) ([]*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.filterEntries(func(e *Entry) bool { return e.FeedID == feedID }), nil
+}
+
+func (s *MemoryStore) GetStarredEntries() ([]*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.filterEntries(func(e *Entry) bool { return e.Starred }), nil
+}
+
+func (s *MemoryStore) GetRevisions(entryID int64) ([]*Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.revisions[entryID]
+	revisions := make([]*Revision, len(stored))
+	for i, rev := range stored {
+		r := *rev
+		revisions[len(stored)-1-i] = &r
+	}
+	return revisions, nil
+}
+
+// Get copies of the entries matching keep, newest first. Must hold s.mu.
+func (s *MemoryStore) filterEntries(keep func(*Entry) bool) []*Entry {
+	var entries []*Entry
+	for _, e := range s.entries {
+		if keep(e) {
+			entries = append(entries, copyEntry(e))
+		}
+	}
+	sortNewestFirst(entries)
+	return entries
+}
+
+// Sort entries newest first, like the SQLite store
+func sortNewestFirst(entries []*Entry) {
+	slices.SortFunc(entries, func(a, b *Entry) int {
+		if c := b.Published.Compare(a.Published); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
+func (s *MemoryStore) SetRead(entryID int64, read bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e := s.entries[entryID]; e != nil {
+		e.Read = read
+	}
+	return nil
+}
+
+func (s *MemoryStore) MarkFeedRead(feedID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.entries {
+		if e.FeedID == feedID {
+			e.Read = true
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetStarred(entryID int64, starred bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e := s.entries[entryID]; e != nil {
+		e.Starred = starred
+	}
+	return nil
+}
+
+// Case-insensitive substring search. Title matches rank first, then newer entries.
+func (s *MemoryStore) Search(query string) ([]*SearchResult, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := strings.ToLower(query)
+	contains := func(text string) bool { return strings.Contains(strings.ToLower(text), q) }
+
+	titles := make(map[int64]string, len(s.feeds))
+	for _, f := range s.feeds {
+		titles[f.ID] = f.DisplayTitle()
+	}
+
+	var titleMatches, otherMatches []*Entry
+	for _, e := range s.entries {
+		switch {
+		case contains(e.Title):
+			titleMatches = append(titleMatches, copyEntry(e))
+		case contains(e.Description), contains(e.Content),
+			slices.ContainsFunc(e.Authors, func(p Person) bool { return contains(p.Name) }):
+			otherMatches = append(otherMatches, copyEntry(e))
+		}
+	}
+	sortNewestFirst(titleMatches)
+	sortNewestFirst(otherMatches)
+
+	var results []*SearchResult
+	for _, e := range append(titleMatches, otherMatches...) {
+		if len(results) == searchLimit {
+			break
+		}
+		results = append(results, &SearchResult{
+			Entry:     e,
+			FeedTitle: titles[e.FeedID],
+			Snippet:   likeSnippet(e, query),
+		})
+	}
+	return results, nil
+}
+
+func (s *MemoryStore) PruneFeed(feedID int64, maxEntries int, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := s.filterEntries(func(e *Entry) bool { return e.FeedID == feedID })
+
+	var lastSeen time.Time
+	for _, e := range entries {
+		if e.LastSeen.After(lastSeen) {
+			lastSeen = e.LastSeen
+		}
+	}
+
+	var deleted int64
+	for i, e := range entries {
+		if !e.Read || e.Starred || !e.LastSeen.Before(lastSeen) {
+			continue
+		}
+		if (maxEntries > 0 && i >= maxEntries) || (!before.IsZero() && e.Published.Before(before)) {
+			delete(s.entries, e.ID)
+			delete(s.revisions, e.ID)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *MemoryStore) Vacuum() error {
+	return nil
+}
+
+func copyFeed(f *Feed) *Feed {
+	feed := *f
+	feed.Entries = nil
+	feed.SkipHours = slices.Clone(f.SkipHours)
+	feed.SkipDays = slices.Clone(f.SkipDays)
+	return &feed
+}
+
+func copyEntry(e *Entry) *Entry {
+	entry := *e
+	entry.Authors = slices.Clone(e.Authors)
+	entry.Categories = slices.Clone(e.Categories)
+	entry.Enclosures = slices.Clone(e.Enclosures)
+	return &entry
+}
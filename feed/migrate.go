@@ -0,0 +1,395 @@
+package feed
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// A single schema change. Migrations are applied in order, each in its own
+// transaction, and the number of applied migrations is stored in the
+// database header (PRAGMA user_version).
+type migration struct {
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// Schema migrations, oldest first. Never edit or reorder a migration that has
+// been released; append a new one instead.
+var migrations = []migration{
+	{
+		description: "create feeds and entries tables",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS feeds (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					url TEXT,
+					title TEXT,
+					description TEXT,
+					last_updated DATETIME DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`CREATE TABLE IF NOT EXISTS entries (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					feed_id INTEGER,
+					url TEXT,
+					title TEXT NOT NULL DEFAULT '',
+					description TEXT NOT NULL DEFAULT '',
+					content TEXT NOT NULL DEFAULT '',
+					date_published DATETIME,
+					read INTEGER DEFAULT 0,
+					FOREIGN KEY(feed_id) REFERENCES feeds(id)
+				)`,
+			)
+		},
+	},
+	{
+		description: "identify entries by GUID",
+		up:          migrateEntryGUIDs,
+	},
+	{
+		description: "store entry images, authors, categories and enclosures",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				"ALTER TABLE entries ADD COLUMN image TEXT NOT NULL DEFAULT ''",
+				`CREATE TABLE entry_authors (
+					entry_id INTEGER NOT NULL,
+					position INTEGER NOT NULL,
+					name TEXT NOT NULL DEFAULT '',
+					email TEXT NOT NULL DEFAULT '',
+					FOREIGN KEY(entry_id) REFERENCES entries(id)
+				)`,
+				`CREATE TABLE entry_categories (
+					entry_id INTEGER NOT NULL,
+					position INTEGER NOT NULL,
+					name TEXT NOT NULL,
+					FOREIGN KEY(entry_id) REFERENCES entries(id)
+				)`,
+				`CREATE TABLE entry_enclosures (
+					entry_id INTEGER NOT NULL,
+					position INTEGER NOT NULL,
+					url TEXT NOT NULL,
+					type TEXT NOT NULL DEFAULT '',
+					length INTEGER NOT NULL DEFAULT 0,
+					FOREIGN KEY(entry_id) REFERENCES entries(id)
+				)`,
+				"CREATE INDEX entry_authors_entry ON entry_authors (entry_id)",
+				"CREATE INDEX entry_categories_entry ON entry_categories (entry_id)",
+				"CREATE INDEX entry_enclosures_entry ON entry_enclosures (entry_id)",
+				// Foreign keys are not enforced, so clean up child rows by hand
+				`CREATE TRIGGER entries_delete_children AFTER DELETE ON entries BEGIN
+					DELETE FROM entry_authors WHERE entry_id = OLD.id;
+					DELETE FROM entry_categories WHERE entry_id = OLD.id;
+					DELETE FROM entry_enclosures WHERE entry_id = OLD.id;
+				END`,
+			)
+		},
+	},
+	{
+		description: "store timestamps as unix time",
+		up:          migrateTimestamps,
+	},
+	{
+		description: "add starred entries",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				"ALTER TABLE entries ADD COLUMN starred INTEGER NOT NULL DEFAULT 0",
+				"CREATE INDEX entries_starred ON entries (published DESC) WHERE starred = 1",
+			)
+		},
+	},
+	{
+		description: "track when entries were last seen in their feed",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx, "ALTER TABLE entries ADD COLUMN last_seen INTEGER NOT NULL DEFAULT 0")
+		},
+	},
+	{
+		description: "store feed site link and metadata",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				"ALTER TABLE feeds ADD COLUMN site_url TEXT NOT NULL DEFAULT ''",
+				"ALTER TABLE feeds ADD COLUMN language TEXT NOT NULL DEFAULT ''",
+				"ALTER TABLE feeds ADD COLUMN author TEXT NOT NULL DEFAULT ''",
+				"ALTER TABLE feeds ADD COLUMN image TEXT NOT NULL DEFAULT ''",
+				"ALTER TABLE feeds ADD COLUMN generator TEXT NOT NULL DEFAULT ''",
+				"ALTER TABLE feeds ADD COLUMN ttl INTEGER NOT NULL DEFAULT 0",
+				"CREATE INDEX feeds_url ON feeds (url)",
+			)
+		},
+	},
+	{
+		description: "add entry revisions",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE entry_revisions (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					entry_id INTEGER NOT NULL,
+					title TEXT NOT NULL DEFAULT '',
+					description TEXT NOT NULL DEFAULT '',
+					content TEXT NOT NULL DEFAULT '',
+					updated INTEGER NOT NULL DEFAULT 0,
+					replaced INTEGER NOT NULL DEFAULT 0
+				)`,
+				"CREATE INDEX entry_revisions_entry ON entry_revisions (entry_id, id)",
+				"DROP TRIGGER entries_delete_children",
+				`CREATE TRIGGER entries_delete_children AFTER DELETE ON entries BEGIN
+					DELETE FROM entry_authors WHERE entry_id = OLD.id;
+					DELETE FROM entry_categories WHERE entry_id = OLD.id;
+					DELETE FROM entry_enclosures WHERE entry_id = OLD.id;
+					DELETE FROM entry_revisions WHERE entry_id = OLD.id;
+				END`,
+			)
+		},
+	},
+	{
+		description: "manage subscriptions in the database",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				"ALTER TABLE feeds ADD COLUMN custom_title TEXT NOT NULL DEFAULT ''",
+				"ALTER TABLE feeds ADD COLUMN paused INTEGER NOT NULL DEFAULT 0",
+				`CREATE TABLE unsubscribed (
+					url TEXT PRIMARY KEY,
+					removed INTEGER NOT NULL DEFAULT 0
+				)`,
+			)
+		},
+	},
+	{
+		description: "store HTTP cache validators",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				"ALTER TABLE feeds ADD COLUMN etag TEXT NOT NULL DEFAULT ''",
+				"ALTER TABLE feeds ADD COLUMN last_modified TEXT NOT NULL DEFAULT ''",
+			)
+		},
+	},
+	{
+		description: "track feed sync status",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				"ALTER TABLE feeds ADD COLUMN last_attempt INTEGER NOT NULL DEFAULT 0",
+				"ALTER TABLE feeds ADD COLUMN http_status INTEGER NOT NULL DEFAULT 0",
+				"ALTER TABLE feeds ADD COLUMN failures INTEGER NOT NULL DEFAULT 0",
+				"ALTER TABLE feeds ADD COLUMN last_error TEXT NOT NULL DEFAULT ''",
+			)
+		},
+	},
+	{
+		description: "record moved and gone feeds",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				"ALTER TABLE feeds ADD COLUMN gone INTEGER NOT NULL DEFAULT 0",
+				`CREATE TABLE moved_feeds (
+					url TEXT PRIMARY KEY,
+					feed_id INTEGER NOT NULL,
+					moved INTEGER NOT NULL DEFAULT 0
+				)`,
+			)
+		},
+	},
+	{
+		description: "schedule feed syncs",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				"ALTER TABLE feeds ADD COLUMN skip_hours TEXT NOT NULL DEFAULT ''",
+				"ALTER TABLE feeds ADD COLUMN skip_days TEXT NOT NULL DEFAULT ''",
+				"ALTER TABLE feeds ADD COLUMN next_sync INTEGER NOT NULL DEFAULT 0",
+				"ALTER TABLE feeds ADD COLUMN newest INTEGER NOT NULL DEFAULT 0",
+				"ALTER TABLE feeds ADD COLUMN unchanged INTEGER NOT NULL DEFAULT 0",
+			)
+		},
+	},
+}
+
+// Latest schema version known to this build.
+func schemaVersion() int {
+	return len(migrations)
+}
+
+// Bring the database schema up to date.
+// Returns an error if the database was created by a newer version of sreader.
+func migrate(db *sql.DB) error {
+	version, err := getUserVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if version > schemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than this version of sreader supports (%d)", version, schemaVersion())
+	}
+
+	for version < schemaVersion() {
+		if version, err = applyMigration(db, version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Apply the migration following version in a transaction.
+// Returns the schema version after the transaction.
+func applyMigration(db *sql.DB, version int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return version, err
+	}
+	defer tx.Rollback()
+
+	// Another process may have migrated the database in the meantime
+	current, err := getUserVersion(tx)
+	if err != nil {
+		return version, err
+	}
+	if current != version {
+		return current, nil
+	}
+
+	m := migrations[version]
+	log.Printf("Migrating database to version %d: %s\n", version+1, m.description)
+	if err = m.up(tx); err != nil {
+		return version, fmt.Errorf("migration %d (%s): %w", version+1, m.description, err)
+	}
+
+	// PRAGMA statements do not support bound parameters
+	if _, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+		return version, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return version, err
+	}
+	return version + 1, nil
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func getUserVersion(q queryRower) (int, error) {
+	var version int
+	err := q.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// Add the guid column and fill it in for existing entries.
+// Entries with a link get a legacy GUID derived from it, which AddEntry
+// replaces with the item's real GUID the next time the item is seen.
+func migrateEntryGUIDs(tx *sql.Tx) error {
+	if _, err := tx.Exec("ALTER TABLE entries ADD COLUMN guid TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT id, COALESCE(url, ''), title, description, content FROM entries")
+	if err != nil {
+		return err
+	}
+
+	guids := make(map[int64]string)
+	for rows.Next() {
+		var (
+			id                               int64
+			url, title, description, content string
+		)
+		if err := rows.Scan(&id, &url, &title, &description, &content); err != nil {
+			rows.Close()
+			return err
+		}
+		if url != "" {
+			guids[id] = legacyGUIDPrefix + url
+		} else {
+			guids[id] = contentHash(title, description, content)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, guid := range guids {
+		if _, err := tx.Exec("UPDATE entries SET guid = ? WHERE id = ?", guid, id); err != nil {
+			return err
+		}
+	}
+
+	// Collapse duplicates onto the oldest row, keeping it read if any copy was
+	return execAll(tx,
+		`UPDATE entries SET read = 1 WHERE id IN (
+			SELECT MIN(id) FROM entries GROUP BY feed_id, guid HAVING COUNT(*) > 1 AND MAX(read) = 1
+		)`,
+		`DELETE FROM entries WHERE id NOT IN (
+			SELECT MIN(id) FROM entries GROUP BY feed_id, guid
+		)`,
+		"CREATE UNIQUE INDEX entries_feed_guid ON entries (feed_id, guid)",
+	)
+}
+
+// Replace the date_published text column with unix published/updated columns,
+// and convert feeds.last_updated to unix time.
+// Dates that cannot be parsed (most of them, due to a bad layout string in
+// earlier versions) are set to 0.
+func migrateTimestamps(tx *sql.Tx) error {
+	err := execAll(tx,
+		"ALTER TABLE entries ADD COLUMN published INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE entries ADD COLUMN updated INTEGER NOT NULL DEFAULT 0",
+	)
+	if err != nil {
+		return err
+	}
+
+	// Cast to keep the driver from converting DATETIME values itself
+	rows, err := tx.Query("SELECT id, CAST(date_published AS TEXT) FROM entries WHERE date_published IS NOT NULL")
+	if err != nil {
+		return err
+	}
+
+	published := make(map[int64]int64)
+	for rows.Next() {
+		var id int64
+		var date string
+		if err := rows.Scan(&id, &date); err != nil {
+			rows.Close()
+			return err
+		}
+		if t, ok := parseDate(date); ok {
+			published[id] = t.Unix()
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, seconds := range published {
+		if _, err := tx.Exec("UPDATE entries SET published = ? WHERE id = ?", seconds, id); err != nil {
+			return err
+		}
+	}
+
+	return execAll(tx,
+		"ALTER TABLE entries DROP COLUMN date_published",
+		"CREATE INDEX entries_feed_published ON entries (feed_id, published DESC)",
+		// SQLite cannot change a column's type in place, so rebuild feeds
+		`CREATE TABLE feeds_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT,
+			title TEXT,
+			description TEXT,
+			last_updated INTEGER NOT NULL DEFAULT 0
+		)`,
+		`INSERT INTO feeds_new (id, url, title, description, last_updated)
+			SELECT id, url, title, description, COALESCE(CAST(strftime('%s', last_updated) AS INTEGER), 0) FROM feeds`,
+		"DROP TABLE feeds",
+		"ALTER TABLE feeds_new RENAME TO feeds",
+	)
+}
+
+// Execute statements in order, stopping at the first error.
+func execAll(tx *sql.Tx, stmts ...string) error {
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
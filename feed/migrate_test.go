@@ -0,0 +1,101 @@
+package feed
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Tables as created by sreader before schema migrations
+var baselineSchema = []string{
+	`CREATE TABLE feeds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT,
+		title TEXT,
+		description TEXT,
+		last_updated DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE entries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		feed_id INTEGER,
+		url TEXT,
+		title TEXT NOT NULL DEFAULT '',
+		description TEXT NOT NULL DEFAULT '',
+		content TEXT NOT NULL DEFAULT '',
+		date_published DATETIME,
+		read INTEGER DEFAULT 0,
+		FOREIGN KEY(feed_id) REFERENCES feeds(id)
+	)`,
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := append(baselineSchema,
+		`INSERT INTO feeds (id, url, title, description, last_updated)
+			VALUES (1, 'https://example.com/feed', 'Feed', 'About', '2024-01-02 03:04:05')`,
+		`INSERT INTO entries (id, feed_id, url, title, description, content, date_published, read) VALUES
+			(1, 1, 'https://example.com/a', 'A', '', '', '2024-01-01T00:00:00Z', 0),
+			(2, 1, 'https://example.com/a', 'A again', '', '', '2024-01-01T00:00:00Z', 1),
+			(3, 1, NULL, 'No link', 'Text', '', NULL, 0),
+			(4, 1, '', 'No link', 'Text', '', 'not a date', 0),
+			(5, 1, 'https://example.com/b', 'B', '', '', 'Mon, 02 Jan 2006 15:04:05 +0000', 0)`,
+	)
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	s, err := OpenSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if version, err := getUserVersion(s.db); err != nil || version != schemaVersion() {
+		t.Errorf("user_version is %d, %v; want %d", version, err, schemaVersion())
+	}
+
+	feed, err := s.GetFeedByURL("https://example.com/feed")
+	if err != nil || feed == nil {
+		t.Fatalf("got feed %v, %v", feed, err)
+	}
+	if want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC); !feed.LastUpdated.Equal(want) {
+		t.Errorf("feed last updated %v, want %v", feed.LastUpdated, want)
+	}
+
+	// Duplicates collapse onto the oldest row, which stays read if a copy was
+	entries, err := s.GetEntries(feed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]struct {
+		guid      string
+		read      bool
+		published time.Time
+	}{
+		1: {legacyGUIDPrefix + "https://example.com/a", true, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		3: {contentHash("No link", "Text", ""), false, time.Time{}},
+		5: {legacyGUIDPrefix + "https://example.com/b", false, time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+	}
+	if len(entries) != len(want) {
+		t.Errorf("got %d entries, want %d", len(entries), len(want))
+	}
+	for _, e := range entries {
+		w, ok := want[e.ID]
+		if !ok {
+			t.Errorf("entry %d (%s) was kept", e.ID, e.Title)
+			continue
+		}
+		if e.GUID != w.guid || e.Read != w.read || !e.Published.Equal(w.published) {
+			t.Errorf("entry %d has GUID %q, read %v, published %v; want %q, %v, %v",
+				e.ID, e.GUID, e.Read, e.Published, w.guid, w.read, w.published)
+		}
+	}
+}
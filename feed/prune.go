@@ -0,0 +1,81 @@
+package feed
+
+import (
+	"log"
+	"time"
+
+	"github.com/bmoneill/sreader/config"
+)
+
+// Delete old entries according to the retention settings (MaxEntries and
+// MaxAgeDays, globally or per feed), then VACUUM if enabled.
+// Starred and unread entries are never deleted, and neither are entries still
+// present in the feed as of the last sync, since they would be added again
+// (as unread) on the next one.
+func Prune(s Store) error {
+	feeds, err := s.ListFeeds()
+	if err != nil {
+		return err
+	}
+
+	var deleted int64
+	for _, f := range feeds {
+		maxEntries, maxAgeDays := config.Config.Retention(f.URL)
+
+		var before time.Time
+		if maxAgeDays > 0 {
+			before = time.Now().AddDate(0, 0, -maxAgeDays)
+		}
+
+		if maxEntries > 0 || !before.IsZero() {
+			n, err := s.PruneFeed(f.ID, maxEntries, before)
+			if err != nil {
+				return err
+			}
+			deleted += n
+		}
+	}
+	log.Println("Pruned", deleted, "entries.")
+
+	if deleted > 0 && config.Config.Vacuum {
+		log.Println("Vacuuming database...")
+		return s.Vacuum()
+	}
+
+	return nil
+}
+
+// Prune the entries of a single feed. Returns the number of deleted entries.
+func (s *SQLiteStore) PruneFeed(feedID int64, maxEntries int, before time.Time) (int64, error) {
+	// Entries that may be deleted at all
+	const prunable = `feed_id = ?1 AND read = 1 AND starred = 0
+		AND last_seen < (SELECT MAX(last_seen) FROM entries WHERE feed_id = ?1)`
+
+	var deleted int64
+	if maxEntries > 0 {
+		res, err := s.db.Exec(`DELETE FROM entries WHERE `+prunable+`
+			AND id NOT IN (SELECT id FROM entries WHERE feed_id = ?1 ORDER BY published DESC, id LIMIT ?2)`,
+			feedID, maxEntries)
+		if err != nil {
+			return deleted, err
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+
+	if !before.IsZero() {
+		res, err := s.db.Exec(`DELETE FROM entries WHERE `+prunable+` AND published < ?2`, feedID, before.Unix())
+		if err != nil {
+			return deleted, err
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+
+	return deleted, nil
+}
+
+func (s *SQLiteStore) Vacuum() error {
+	_, err := s.db.Exec("VACUUM")
+	return err
+}
@@ -0,0 +1,91 @@
+package feed
+
+import (
+	"slices"
+	"time"
+
+	"github.com/bmoneill/sreader/config"
+	"github.com/mmcdole/gofeed"
+)
+
+// Whether a feed is due to be synced at now: its next sync time has come and
+// the feed does not ask to be skipped at this hour or on this day
+func due(feed *Feed, now time.Time) bool {
+	if now.Before(feed.NextSync) {
+		return false
+	}
+	now = now.UTC()
+	return !slices.Contains(feed.SkipHours, now.Hour()) && !slices.Contains(feed.SkipDays, now.Weekday())
+}
+
+// Work out when to sync a feed next after syncing it at now, along with the
+// newest entry time seen in it and the number of syncs in a row that found
+// nothing newer.
+//
+// Feeds are synced every configured interval, or less often if their TTL or
+// the response's Cache-Control max-age says so. The interval doubles for
+// each sync in a row that found nothing new and for each failure in a row,
+// and a server's Retry-After is honored. Waits are capped at MaxInterval
+// (or the interval if it is longer).
+func schedule(feed *Feed, result *fetchResult, now time.Time) (next, newest time.Time, unchanged int) {
+	interval := config.Config.SyncInterval(feed.URL)
+	limit := max(interval, time.Duration(config.Config.MaxInterval)*time.Minute)
+	newest, unchanged = feed.Newest, feed.Unchanged
+
+	var wait time.Duration
+	if result.err != nil {
+		// feed.Failures does not count this failure yet
+		wait = max(doubled(interval, feed.Failures, limit), result.retryAfter)
+	} else {
+		ttl := time.Duration(feed.TTL) * time.Minute
+		if result.parsed != nil {
+			ttl = time.Duration(newFeed(result.parsed).TTL) * time.Minute
+
+			latest, ok := newestItem(result.parsed)
+			switch {
+			case !ok:
+				// Feeds without dates cannot tell whether anything is new
+				unchanged = 0
+			case latest.After(newest):
+				newest, unchanged = latest, 0
+			default:
+				unchanged++
+			}
+		} else {
+			// Not modified
+			unchanged++
+		}
+		wait = max(doubled(interval, unchanged, limit), ttl, result.maxAge)
+	}
+
+	return now.Add(min(wait, limit)), newest, unchanged
+}
+
+// Get the latest publication or update time of a parsed feed's dated items.
+// Returns false if no item is dated.
+func newestItem(feed *gofeed.Feed) (time.Time, bool) {
+	var latest time.Time
+	dated := false
+	for _, item := range feed.Items {
+		published, updated, ok := itemTimes(item, time.Time{})
+		if !ok {
+			continue
+		}
+		dated = true
+		if published.After(latest) {
+			latest = published
+		}
+		if updated.After(latest) {
+			latest = updated
+		}
+	}
+	return latest, dated
+}
+
+// Double interval the given number of times, stopping once it reaches limit
+func doubled(interval time.Duration, times int, limit time.Duration) time.Duration {
+	for i := 0; i < times && interval > 0 && interval < limit; i++ {
+		interval *= 2
+	}
+	return interval
+}
@@ -0,0 +1,204 @@
+package feed
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bmoneill/sreader/config"
+	"github.com/mmcdole/gofeed"
+)
+
+// A Monday at noon
+var scheduleNow = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestDue(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		feed Feed
+		now  time.Time
+		want bool
+	}{
+		{name: "never synced", want: true},
+		{name: "next sync later", feed: Feed{NextSync: scheduleNow.Add(time.Minute)}},
+		{name: "next sync now", feed: Feed{NextSync: scheduleNow}, want: true},
+		{name: "skipped hour", feed: Feed{SkipHours: []int{12}}},
+		{name: "other hours skipped", feed: Feed{SkipHours: []int{11, 13}}, want: true},
+		{name: "skipped day", feed: Feed{SkipDays: []time.Weekday{time.Monday}}},
+		{name: "other days skipped", feed: Feed{SkipDays: []time.Weekday{time.Sunday}}, want: true},
+		{
+			name: "skipped hour in UTC",
+			feed: Feed{SkipHours: []int{12}},
+			now:  scheduleNow.In(time.FixedZone("UTC+5", 5*60*60)),
+		},
+	} {
+		now := test.now
+		if now.IsZero() {
+			now = scheduleNow
+		}
+		if got := due(&test.feed, now); got != test.want {
+			t.Errorf("%s: due is %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	setConfig(t, func(c *config.SreaderConfig) { c.Interval, c.MaxInterval = 30, 240 })
+	failed := errors.New("503 Service Unavailable")
+	before, after := scheduleNow.Add(-2*time.Hour), scheduleNow.Add(-time.Hour)
+
+	// Make a parsed feed with the given channel values and items dated at times
+	parsed := func(custom map[string]string, times ...time.Time) *gofeed.Feed {
+		feed := &gofeed.Feed{Custom: custom}
+		for _, date := range times {
+			feed.Items = append(feed.Items, &gofeed.Item{PublishedParsed: &date})
+		}
+		if len(times) == 0 {
+			feed.Items = []*gofeed.Item{{Title: "Undated"}}
+		}
+		return feed
+	}
+
+	for _, test := range []struct {
+		name      string
+		feed      Feed
+		result    fetchResult
+		wait      time.Duration
+		newest    time.Time
+		unchanged int
+	}{
+		{
+			name:   "new entries",
+			feed:   Feed{Newest: before, Unchanged: 3},
+			result: fetchResult{parsed: parsed(nil, before, after)},
+			wait:   30 * time.Minute,
+			newest: after,
+		},
+		{
+			name:      "no new entries",
+			feed:      Feed{Newest: after, Unchanged: 1},
+			result:    fetchResult{parsed: parsed(nil, before, after)},
+			wait:      2 * 2 * 30 * time.Minute,
+			newest:    after,
+			unchanged: 2,
+		},
+		{
+			name:   "undated entries",
+			feed:   Feed{Newest: after, Unchanged: 3},
+			result: fetchResult{parsed: parsed(nil)},
+			wait:   30 * time.Minute,
+			newest: after,
+		},
+		{
+			name:      "not modified",
+			feed:      Feed{Newest: after},
+			result:    fetchResult{status: 304},
+			wait:      2 * 30 * time.Minute,
+			newest:    after,
+			unchanged: 1,
+		},
+		{
+			name:      "not modified at the limit",
+			feed:      Feed{Unchanged: 5},
+			result:    fetchResult{status: 304},
+			wait:      240 * time.Minute,
+			unchanged: 6,
+		},
+		{
+			name:   "ttl",
+			result: fetchResult{parsed: parsed(map[string]string{ttlKey: "90"}, after)},
+			wait:   90 * time.Minute,
+			newest: after,
+		},
+		{
+			name:   "ttl above the limit",
+			result: fetchResult{parsed: parsed(map[string]string{ttlKey: "600"}, after)},
+			wait:   240 * time.Minute,
+			newest: after,
+		},
+		{
+			name:      "stored ttl when not modified",
+			feed:      Feed{TTL: 100},
+			result:    fetchResult{status: 304},
+			wait:      100 * time.Minute,
+			unchanged: 1,
+		},
+		{
+			name:   "max-age",
+			result: fetchResult{parsed: parsed(nil, after), maxAge: 2 * time.Hour},
+			wait:   2 * time.Hour,
+			newest: after,
+		},
+		{
+			name:   "first failure",
+			result: fetchResult{err: failed},
+			wait:   30 * time.Minute,
+		},
+		{
+			name:   "failures in a row",
+			feed:   Feed{Failures: 2},
+			result: fetchResult{err: failed},
+			wait:   2 * 2 * 30 * time.Minute,
+		},
+		{
+			name:   "failures at the limit",
+			feed:   Feed{Failures: 10},
+			result: fetchResult{err: failed},
+			wait:   240 * time.Minute,
+		},
+		{
+			name:      "failure keeps the count of unchanged syncs",
+			feed:      Feed{Newest: after, Unchanged: 2},
+			result:    fetchResult{err: failed},
+			wait:      30 * time.Minute,
+			newest:    after,
+			unchanged: 2,
+		},
+		{
+			name:   "retry-after",
+			result: fetchResult{err: failed, retryAfter: 3 * time.Hour},
+			wait:   3 * time.Hour,
+		},
+		{
+			name:   "retry-after sooner than the backoff",
+			feed:   Feed{Failures: 2},
+			result: fetchResult{err: failed, retryAfter: time.Minute},
+			wait:   2 * 2 * 30 * time.Minute,
+		},
+		{
+			name:   "retry-after above the limit",
+			result: fetchResult{err: failed, retryAfter: 10 * time.Hour},
+			wait:   240 * time.Minute,
+		},
+	} {
+		test.feed.URL = "https://example.com/feed"
+		next, newest, unchanged := schedule(&test.feed, &test.result, scheduleNow)
+		if want := scheduleNow.Add(test.wait); !next.Equal(want) {
+			t.Errorf("%s: next sync in %v, want %v", test.name, next.Sub(scheduleNow), test.wait)
+		}
+		if !newest.Equal(test.newest) {
+			t.Errorf("%s: newest entry at %v, want %v", test.name, newest, test.newest)
+		}
+		if unchanged != test.unchanged {
+			t.Errorf("%s: %d unchanged syncs, want %d", test.name, unchanged, test.unchanged)
+		}
+	}
+}
+
+func TestDoubled(t *testing.T) {
+	for _, test := range []struct {
+		interval time.Duration
+		times    int
+		want     time.Duration
+	}{
+		{time.Minute, 0, time.Minute},
+		{time.Minute, 3, 8 * time.Minute},
+		{time.Minute, 100, 16 * time.Minute},
+		{10 * time.Minute, 1, 10 * time.Minute},
+		{0, 5, 0},
+	} {
+		if got := doubled(test.interval, test.times, 10*time.Minute); got != test.want {
+			t.Errorf("doubled(%v, %d) = %v, want %v", test.interval, test.times, got, test.want)
+		}
+	}
+}
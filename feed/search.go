@@ -0,0 +1,213 @@
+package feed
+
+import (
+	"database/sql"
+	"log"
+	"slices"
+	"strings"
+)
+
+// Maximum number of results returned by Search
+const searchLimit = 200
+
+type SearchResult struct {
+	Entry     *Entry `json:"entry"`
+	FeedTitle string `json:"feed_title"`
+	Snippet   string `json:"snippet"`
+}
+
+// Triggers keeping entries_fts in sync with entries and entry_authors.
+// The index table stores its own copy of the text, keyed by entry ID.
+var searchTriggers = map[string]string{
+	"entries_fts_insert": `CREATE TRIGGER entries_fts_insert AFTER INSERT ON entries BEGIN
+		INSERT INTO entries_fts (rowid, title, description, content, author)
+			VALUES (NEW.id, NEW.title, NEW.description, NEW.content, '');
+	END`,
+	"entries_fts_update": `CREATE TRIGGER entries_fts_update AFTER UPDATE OF title, description, content ON entries BEGIN
+		UPDATE entries_fts SET title = NEW.title, description = NEW.description, content = NEW.content
+			WHERE rowid = NEW.id;
+	END`,
+	"entries_fts_delete": `CREATE TRIGGER entries_fts_delete AFTER DELETE ON entries BEGIN
+		DELETE FROM entries_fts WHERE rowid = OLD.id;
+	END`,
+	"entries_fts_author_insert": `CREATE TRIGGER entries_fts_author_insert AFTER INSERT ON entry_authors BEGIN
+		UPDATE entries_fts SET author = ` + authorText("NEW.entry_id") + ` WHERE rowid = NEW.entry_id;
+	END`,
+	"entries_fts_author_delete": `CREATE TRIGGER entries_fts_author_delete AFTER DELETE ON entry_authors BEGIN
+		UPDATE entries_fts SET author = ` + authorText("OLD.entry_id") + ` WHERE rowid = OLD.entry_id;
+	END`,
+}
+
+// SQL expression for the searchable author text of entry id
+func authorText(id string) string {
+	return "COALESCE((SELECT group_concat(name || ' ' || email, ' ') FROM entry_authors WHERE entry_id = " + id + "), '')"
+}
+
+// Whether the SQLite library was built with FTS5 (go build -tags sqlite_fts5)
+func hasFTS5(db *sql.DB) bool {
+	var used bool
+	err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&used)
+	return err == nil && used
+}
+
+// Create the full-text search index, or rebuild it if it was not kept up to date.
+// The index is managed here rather than in a migration because it depends on
+// how sreader was built: without FTS5 the sync triggers are dropped (they
+// would make every write fail) and Search falls back to substring matching.
+// The next FTS5-enabled build notices the missing triggers and rebuilds.
+func initSearchIndex(db *sql.DB) error {
+	if !hasFTS5(db) {
+		log.Println("SQLite was built without FTS5, falling back to substring search.")
+		for name := range searchTriggers {
+			if _, err := db.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var triggers int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'entries_fts_%'").Scan(&triggers)
+	if err != nil {
+		return err
+	}
+	if triggers == len(searchTriggers) {
+		return nil
+	}
+
+	log.Println("Building search index...")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = execAll(tx,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS entries_fts USING fts5 (
+			title, description, content, author,
+			tokenize = 'porter unicode61'
+		)`,
+		"DELETE FROM entries_fts",
+		`INSERT INTO entries_fts (rowid, title, description, content, author)
+			SELECT id, title, description, content, `+authorText("entries.id")+` FROM entries`,
+	)
+	if err != nil {
+		return err
+	}
+
+	for name, stmt := range searchTriggers {
+		if _, err := tx.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Convert user input to an FTS5 query matching entries containing every word,
+// treating the last word as a prefix. Words are quoted so that punctuation in
+// the input is never interpreted as query syntax.
+func ftsQuery(query string) string {
+	words := strings.Fields(query)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += "*"
+	}
+	return strings.Join(words, " ")
+}
+
+// Search all entries for query, best matches first.
+// Snippets mark matched words with [brackets].
+func (s *SQLiteStore) Search(query string) ([]*SearchResult, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+
+	var rows *sql.Rows
+	var err error
+	if hasFTS5(s.db) {
+		rows, err = s.db.Query(`SELECT `+entryColumns+`, COALESCE(NULLIF(f.custom_title, ''), NULLIF(f.title, ''), f.url, ''),
+				snippet(entries_fts, -1, '[', ']', '...', 12)
+			FROM entries_fts
+			JOIN entries e ON e.id = entries_fts.rowid
+			LEFT JOIN feeds f ON f.id = e.feed_id
+			WHERE entries_fts MATCH ?
+			ORDER BY bm25(entries_fts, 10.0, 2.0, 1.0, 2.0)
+			LIMIT ?`, ftsQuery(query), searchLimit)
+	} else {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+		rows, err = s.db.Query(`SELECT `+entryColumns+`, COALESCE(NULLIF(f.custom_title, ''), NULLIF(f.title, ''), f.url, ''), ''
+			FROM entries e
+			LEFT JOIN feeds f ON f.id = e.feed_id
+			WHERE e.title LIKE ?1 ESCAPE '\' OR e.description LIKE ?1 ESCAPE '\' OR e.content LIKE ?1 ESCAPE '\'
+				OR EXISTS (SELECT 1 FROM entry_authors a WHERE a.entry_id = e.id AND a.name LIKE ?1 ESCAPE '\')
+			ORDER BY e.title LIKE ?1 ESCAPE '\' DESC, e.published DESC
+			LIMIT ?2`, pattern, searchLimit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*SearchResult
+	for rows.Next() {
+		var result SearchResult
+		entry, err := scanEntry(rows, &result.FeedTitle, &result.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		if result.Snippet == "" {
+			result.Snippet = likeSnippet(entry, query)
+		}
+		result.Entry = entry
+		results = append(results, &result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Entry, len(results))
+	for i, result := range results {
+		entries[i] = result.Entry
+	}
+	return results, s.loadEntryChildren(entries)
+}
+
+// Build a snippet around the first match of query when FTS5 is unavailable.
+// Works on runes, since lower-casing can change the length of a string in bytes.
+func likeSnippet(entry *Entry, query string) string {
+	const radius = 40
+	q := []rune(strings.ToLower(query))
+	for _, field := range []string{entry.Description, entry.Content, entry.Title} {
+		text := []rune(field)
+		i := indexRunes([]rune(strings.ToLower(field)), q)
+		if i < 0 {
+			continue
+		}
+		start, end := max(i-radius, 0), min(i+len(q)+radius, len(text))
+		snippet := string(text[start:i]) + "[" + string(text[i:i+len(q)]) + "]" + string(text[i+len(q):end])
+		if start > 0 {
+			snippet = "..." + snippet
+		}
+		if end < len(text) {
+			snippet += "..."
+		}
+		return snippet
+	}
+	return ""
+}
+
+// Get the index of the first occurrence of sub in runes, or -1
+func indexRunes(runes, sub []rune) int {
+	for i := 0; i+len(sub) <= len(runes); i++ {
+		if slices.Equal(runes[i:i+len(sub)], sub) {
+			return i
+		}
+	}
+	return -1
+}
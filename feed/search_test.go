@@ -0,0 +1,77 @@
+package feed
+
+import (
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLikeSnippet(t *testing.T) {
+	for _, test := range []struct {
+		title, query, want string
+	}{
+		{"Breaking news today", "NEWS", "Breaking [news] today"},
+		{"ȺȺ news", "news", "ȺȺ [news]"},
+		{"ȺȺ NEWS", "ⱥⱥ", "[ȺȺ] NEWS"},
+		{"İstanbul news", "news", "İstanbul [news]"},
+		{"no match", "news", ""},
+	} {
+		if got := likeSnippet(&Entry{Title: test.title}, test.query); got != test.want {
+			t.Errorf("likeSnippet(%q, %q) = %q, want %q", test.title, test.query, got, test.want)
+		}
+	}
+}
+
+func TestFTSQuery(t *testing.T) {
+	for _, test := range []struct {
+		query, want string
+	}{
+		{"rocket", `"rocket"*`},
+		{"rocket launch", `"rocket" "launch"*`},
+		{`say "hi"`, `"say" """hi"""*`},
+		{"a OR b*", `"a" "OR" "b*"*`},
+		{"  ", ""},
+	} {
+		if got := ftsQuery(test.query); got != test.want {
+			t.Errorf("ftsQuery(%q) = %s, want %s", test.query, got, test.want)
+		}
+	}
+}
+
+func TestSearchIndexRebuild(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := OpenSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasFTS5(s.db) {
+		s.Close()
+		t.Skip("SQLite was built without FTS5")
+	}
+	updates := testEntries("https://example.com/feed", 1)
+	updates[0].Entry.Title = "Rocket launch"
+	if _, err := s.UpdateFeed(&Feed{URL: "https://example.com/feed"}, updates); err != nil {
+		t.Fatal(err)
+	}
+
+	// As left by a build without FTS5
+	for _, name := range slices.Sorted(maps.Keys(searchTriggers)) {
+		if _, err := s.db.Exec("DROP TRIGGER " + name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.db.Exec("DELETE FROM entries_fts"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = OpenSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := searchTitles(t, s, "rocket"); !slices.Equal(got, []string{"Rocket launch"}) {
+		t.Errorf("search after rebuilding found %v", got)
+	}
+}
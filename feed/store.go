@@ -0,0 +1,257 @@
+package feed
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bmoneill/sreader/config"
+)
+
+type Entry struct {
+	ID          int64       `json:"id"`
+	FeedID      int64       `json:"feed_id"`
+	GUID        string      `json:"guid"`
+	URL         string      `json:"url"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Content     string      `json:"content"`
+	Published   time.Time   `json:"published"`
+	Updated     time.Time   `json:"updated,omitempty"`
+	Image       string      `json:"image,omitempty"`
+	Authors     []Person    `json:"authors,omitempty"`
+	Categories  []string    `json:"categories,omitempty"`
+	Enclosures  []Enclosure `json:"enclosures,omitempty"`
+	Read        bool        `json:"read"`
+	Starred     bool        `json:"starred"`
+	LastSeen    time.Time   `json:"last_seen"`           // Last time the entry was in the fetched feed
+	Revisions   int         `json:"revisions,omitempty"` // Number of stored earlier versions
+}
+
+// An earlier version of an entry, kept when the feed changed its text
+type Revision struct {
+	ID          int64     `json:"id"`
+	EntryID     int64     `json:"entry_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Content     string    `json:"content"`
+	Updated     time.Time `json:"updated,omitempty"` // The entry's update time in this version
+	Replaced    time.Time `json:"replaced"`          // When the next version was fetched
+}
+
+type Person struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+}
+
+// Attached media file, e.g. a podcast episode
+type Enclosure struct {
+	URL    string `json:"url"`
+	Type   string `json:"type,omitempty"`
+	Length int64  `json:"length,omitempty"`
+}
+
+type Feed struct {
+	ID           int64          `json:"id"`
+	URL          string         `json:"url"`      // Subscription URL the feed is fetched from
+	SiteURL      string         `json:"site_url"` // Website the feed belongs to
+	Title        string         `json:"title"`
+	CustomTitle  string         `json:"custom_title,omitempty"` // Title set by the user, shown instead of Title
+	Paused       bool           `json:"paused"`                 // Paused feeds are not synced
+	Description  string         `json:"description"`
+	Language     string         `json:"language,omitempty"`
+	Author       string         `json:"author,omitempty"`
+	Image        string         `json:"image,omitempty"` // Logo or icon URL
+	Generator    string         `json:"generator,omitempty"`
+	TTL          int            `json:"ttl,omitempty"`           // Minutes the feed may be cached, 0 if unknown
+	SkipHours    []int          `json:"skip_hours,omitempty"`    // Hours (UTC) the feed asks not to be synced in
+	SkipDays     []time.Weekday `json:"skip_days,omitempty"`     // Days (UTC) the feed asks not to be synced on
+	ETag         string         `json:"etag,omitempty"`          // ETag of the last stored response
+	LastModified string         `json:"last_modified,omitempty"` // Last-Modified of the last stored response
+	LastUpdated  time.Time      `json:"last_updated"`            // Last successful sync
+	LastAttempt  time.Time      `json:"last_attempt"`            // Last sync, successful or not
+	HTTPStatus   int            `json:"http_status"`             // Response status of the last sync, 0 if there was none
+	Failures     int            `json:"failures"`                // Consecutive failed syncs
+	LastError    string         `json:"last_error,omitempty"`    // Why the last sync failed, if it did
+	Gone         bool           `json:"gone"`                    // The server said the feed is gone for good (410), so it is not synced
+	NextSync     time.Time      `json:"next_sync"`               // When the feed is due to be synced, zero if it is due now
+	Newest       time.Time      `json:"newest"`                  // Latest publication or update time of the feed's dated entries
+	Unchanged    int            `json:"unchanged"`               // Syncs in a row that found nothing newer than Newest
+	Entries      []*Entry       `json:"entries,omitempty"`
+}
+
+// ID of the pseudo-feed returned by GetStarredFeed
+const StarredFeedID int64 = -1
+
+// Get the title to show for a feed: the user's title, the feed's own title,
+// or its URL if it has not been synced yet
+func (f *Feed) DisplayTitle() string {
+	switch {
+	case f.CustomTitle != "":
+		return f.CustomTitle
+	case f.Title != "":
+		return f.Title
+	}
+	return f.URL
+}
+
+// Count the unread entries of a feed
+func (f *Feed) UnreadCount() int {
+	unread := 0
+	for _, entry := range f.Entries {
+		if !entry.Read {
+			unread++
+		}
+	}
+	return unread
+}
+
+// Persistent storage for feeds, entries and their read/starred state.
+// SQLiteStore is the default implementation; MemoryStore keeps everything in
+// memory, which is useful for tests and for embedding sreader's sync logic.
+type Store interface {
+	// Get all stored feeds, without their entries
+	ListFeeds() ([]*Feed, error)
+
+	// Get the feed stored for url with its entries, or nil if there is none
+	GetFeedByURL(url string) (*Feed, error)
+
+	// Add a feed, or update the metadata (everything but the ID, URL, sync
+	// status and schedule, custom title and paused state) of the feed with the
+	// same URL.
+	// Returns the feed's ID.
+	AddFeed(feed *Feed) (int64, error)
+
+	// Subscribe to the feed at url unless already subscribed. The feed has no
+	// metadata or entries until it is synced. Returns the feed's ID.
+	Subscribe(url string) (int64, error)
+
+	// Subscribe to each of urls that is neither subscribed to nor was
+	// unsubscribed from. Returns the number of new subscriptions.
+	Import(urls []string) (int, error)
+
+	// Delete a feed and all its entries. Import skips its URL from now on.
+	Unsubscribe(feedID int64) error
+
+	// Set the title shown for a feed, or "" to show the feed's own title
+	RenameFeed(feedID int64, title string) error
+
+	// Pause or resume syncing a feed
+	SetPaused(feedID int64, paused bool) error
+
+	// Change the URL of a feed that moved permanently, keeping its entries.
+	// If a feed with the new URL exists, the entries it lacks are moved to it
+	// and the old feed is deleted. Subscribe and Import treat the old URL as
+	// the new one from now on. Returns the ID of the feed at url.
+	MoveFeed(feedID int64, url string) (int64, error)
+
+	// Record a successful sync of a feed, with the HTTP status of the response
+	RecordSuccess(feedID int64, status int) error
+
+	// Record a failed sync of a feed. status is 0 if there was no response.
+	// A 410 Gone status marks the feed gone until it is synced successfully.
+	RecordFailure(feedID int64, status int, message string) error
+
+	// Set when a feed is next due to be synced, the newest entry time seen in
+	// it and the number of syncs in a row that found nothing newer
+	SetSchedule(feedID int64, next, newest time.Time, unchanged int) error
+
+	// Add an entry, or update the entry with the same feed ID and GUID.
+	// Sets entry.ID. If dated is false the entry's publication time is only a
+	// guess, and an existing entry keeps its original one. If the title,
+	// description or content of an existing entry changes, the old version is
+	// kept as a revision.
+	AddEntry(entry *Entry, dated bool) error
+
+	// Add a feed as AddFeed does and add or update its entries as AddEntry
+	// does, atomically. Sets the feed ID and ID of each entry.
+	// Returns the feed's ID.
+	UpdateFeed(feed *Feed, updates []EntryUpdate) (int64, error)
+
+	// Get the entries of a feed, newest first
+	GetEntries(feedID int64) ([]*Entry, error)
+
+	// Get the starred entries of all feeds, newest first
+	GetStarredEntries() ([]*Entry, error)
+
+	// Get the earlier versions of an entry, newest first
+	GetRevisions(entryID int64) ([]*Revision, error)
+
+	SetRead(entryID int64, read bool) error
+	MarkFeedRead(feedID int64) error
+	SetStarred(entryID int64, starred bool) error
+
+	// Search all entries, best matches first
+	Search(query string) ([]*SearchResult, error)
+
+	// Delete read, unstarred entries of a feed that are no longer in the feed
+	// itself and are either beyond the newest maxEntries (if maxEntries > 0)
+	// or published before the cutoff (if it is not zero).
+	// Returns the number of deleted entries.
+	PruneFeed(feedID int64, maxEntries int, before time.Time) (int64, error)
+
+	// Reclaim space after pruning
+	Vacuum() error
+
+	// Take the lock that keeps syncs of this store from running at the same
+	// time. If the lock is held, waits for it if wait is set and returns
+	// ErrSyncRunning otherwise. Call the returned function to release it.
+	LockSync(wait bool) (func(), error)
+
+	Close() error
+}
+
+// An entry to add or update with Store.UpdateFeed
+type EntryUpdate struct {
+	Entry      *Entry
+	Dated      bool // False if Entry.Published is only a guess (the fetch time)
+	MarkUnread bool // Mark an existing entry unread if its text changed
+}
+
+// Retrieve all subscribed feeds with their entries
+func GetFeeds(s Store) []*Feed {
+	feeds, err := s.ListFeeds()
+	if err != nil {
+		log.Println("Error loading feeds:", err.Error())
+		return nil
+	}
+
+	for _, f := range feeds {
+		if f.Entries, err = s.GetEntries(f.ID); err != nil {
+			log.Println("Error loading entries of", f.URL+":", err.Error())
+		}
+	}
+	return feeds
+}
+
+// Subscribe to the URLs in the configuration that are new to the database.
+// URLs unsubscribed from in the UI are not added again.
+func ImportConfig(s Store) error {
+	var urls []string
+	for _, url := range config.Config.URLs {
+		if url != nil && strings.TrimSpace(*url) != "" {
+			urls = append(urls, strings.TrimSpace(*url))
+		}
+	}
+
+	n, err := s.Import(urls)
+	if n > 0 {
+		log.Println("Subscribed to", n, "new feeds from the configuration.")
+	}
+	return err
+}
+
+// Get the "Starred" pseudo-feed, holding the starred entries of all feeds
+func GetStarredFeed(s Store) *Feed {
+	entries, err := s.GetStarredEntries()
+	if err != nil {
+		log.Println("Error loading starred entries:", err.Error())
+	}
+
+	return &Feed{
+		ID:          StarredFeedID,
+		Title:       "Starred",
+		Description: "Saved entries from all feeds",
+		Entries:     entries,
+	}
+}
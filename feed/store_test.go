@@ -0,0 +1,382 @@
+package feed
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// Run each store test against every Store implementation
+func TestStores(t *testing.T) {
+	stores := []struct {
+		name string
+		open func(t *testing.T) Store
+	}{
+		{"memory", func(t *testing.T) Store { return NewMemoryStore() }},
+		{"sqlite", func(t *testing.T) Store { return openTestStore(t) }},
+	}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s Store)
+	}{
+		{"dedup", testDedup},
+		{"revisions", testRevisions},
+		{"legacy entries", testLegacyEntries},
+		{"prune", testPrune},
+		{"subscriptions", testSubscriptions},
+		{"move", testMove},
+		{"merge", testMerge},
+		{"search", testSearch},
+	}
+
+	for _, store := range stores {
+		for _, test := range tests {
+			t.Run(store.name+"/"+test.name, func(t *testing.T) {
+				test.run(t, store.open(t))
+			})
+		}
+	}
+}
+
+// Add the feed at url with n entries, returning the feed's ID and the entries
+func addTestFeed(t *testing.T, s Store, url string, n int) (int64, []*Entry) {
+	t.Helper()
+	updates := testEntries(url, n)
+	id, err := s.UpdateFeed(&Feed{URL: url, Title: url}, updates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make([]*Entry, n)
+	for i, u := range updates {
+		entries[i] = u.Entry
+	}
+	return id, entries
+}
+
+func getEntries(t *testing.T, s Store, feedID int64) []*Entry {
+	t.Helper()
+	entries, err := s.GetEntries(feedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func getFeedURLs(t *testing.T, s Store) []string {
+	t.Helper()
+	feeds, err := s.ListFeeds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls := make([]string, len(feeds))
+	for i, f := range feeds {
+		urls[i] = f.URL
+	}
+	return urls
+}
+
+func testDedup(t *testing.T, s Store) {
+	id, entries := addTestFeed(t, s, "https://example.com/feed", 3)
+	if err := s.SetRead(entries[0].ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetStarred(entries[0].ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same entries fetched again
+	_, again := addTestFeed(t, s, "https://example.com/feed", 3)
+	if again[0].ID != entries[0].ID {
+		t.Errorf("entry ID changed from %d to %d", entries[0].ID, again[0].ID)
+	}
+
+	stored := getEntries(t, s, id)
+	if len(stored) != 3 {
+		t.Fatalf("got %d entries, want 3", len(stored))
+	}
+	if !stored[0].Read || !stored[0].Starred {
+		t.Errorf("entry lost its state: read %v, starred %v", stored[0].Read, stored[0].Starred)
+	}
+}
+
+func testRevisions(t *testing.T, s Store) {
+	const url = "https://example.com/feed"
+	_, entries := addTestFeed(t, s, url, 1)
+	if err := s.SetRead(entries[0].ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		title      string
+		markUnread bool
+		revisions  int
+		read       bool
+	}{
+		{"Entry 0", true, 0, true},       // Unchanged
+		{"Edited", false, 1, true},       // Changed, kept read
+		{"Edited again", true, 2, false}, // Changed, marked unread
+	} {
+		updates := testEntries(url, 1)
+		updates[0].Entry.Title = test.title
+		updates[0].MarkUnread = test.markUnread
+		id, err := s.UpdateFeed(&Feed{URL: url}, updates)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entry := getEntries(t, s, id)[0]
+		if entry.Title != test.title || entry.Revisions != test.revisions || entry.Read != test.read {
+			t.Errorf("after %q: title %q, %d revisions, read %v; want %d revisions, read %v",
+				test.title, entry.Title, entry.Revisions, entry.Read, test.revisions, test.read)
+		}
+	}
+
+	revisions, err := s.GetRevisions(entries[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Title != "Edited" || revisions[1].Title != "Entry 0" {
+		t.Errorf("got revisions %v, want Edited and Entry 0", revisions)
+	}
+}
+
+func testLegacyEntries(t *testing.T, s Store) {
+	const url = "https://example.com/feed"
+	feedID, err := s.AddFeed(&Feed{URL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stored without content and never seen by a sync
+	legacy := &Entry{FeedID: feedID, GUID: "guid", Title: "Title", Published: time.Now()}
+	if err := s.AddEntry(legacy, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetRead(legacy.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := &Entry{FeedID: feedID, GUID: "guid", Title: "Title", Content: "Content",
+		Published: legacy.Published, LastSeen: time.Now()}
+	_, err = s.UpdateFeed(&Feed{URL: url}, []EntryUpdate{{Entry: entry, Dated: true, MarkUnread: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := getEntries(t, s, feedID)[0]
+	if stored.Content != "Content" || stored.Revisions != 0 || !stored.Read {
+		t.Errorf("got content %q, %d revisions, read %v; want the content, no revisions, read",
+			stored.Content, stored.Revisions, stored.Read)
+	}
+}
+
+func testPrune(t *testing.T, s Store) {
+	id, entries := addTestFeed(t, s, "https://example.com/feed", 6)
+
+	// Only the newest two are still in the feed
+	updates := testEntries("https://example.com/feed", 2)
+	for _, u := range updates {
+		u.Entry.LastSeen = u.Entry.LastSeen.Add(time.Hour)
+	}
+	if _, err := s.UpdateFeed(&Feed{URL: "https://example.com/feed"}, updates); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entry 3 stays unread and entry 4 starred
+	for i, e := range entries {
+		if err := s.SetRead(e.ID, i != 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetStarred(entries[4].ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := s.PruneFeed(id, 1, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("deleted %d entries, want 2", deleted)
+	}
+
+	var titles []string
+	for _, e := range getEntries(t, s, id) {
+		titles = append(titles, e.Title)
+	}
+	want := []string{"Entry 0", "Entry 1", "Entry 3", "Entry 4"}
+	if !slices.Equal(titles, want) {
+		t.Errorf("kept %v, want %v", titles, want)
+	}
+}
+
+func testSubscriptions(t *testing.T, s Store) {
+	urls := []string{"https://example.com/a", "https://example.com/b"}
+	if added, err := s.Import(urls); err != nil || added != 2 {
+		t.Fatalf("Import added %d, %v; want 2", added, err)
+	}
+	if added, err := s.Import(urls); err != nil || added != 0 {
+		t.Fatalf("Import again added %d, %v; want 0", added, err)
+	}
+
+	id, err := s.Subscribe(urls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Unsubscribe(id); err != nil {
+		t.Fatal(err)
+	}
+	if added, err := s.Import(urls); err != nil || added != 0 {
+		t.Errorf("Import after unsubscribing added %d, %v; want 0", added, err)
+	}
+	if got := getFeedURLs(t, s); !slices.Equal(got, urls[1:]) {
+		t.Errorf("got feeds %v, want %v", got, urls[1:])
+	}
+
+	if _, err := s.Subscribe(urls[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got := getFeedURLs(t, s); len(got) != 2 {
+		t.Errorf("got feeds %v after subscribing again, want both", got)
+	}
+}
+
+func testMove(t *testing.T, s Store) {
+	const oldURL, newURL = "https://example.com/old", "https://example.com/new"
+	id, _ := addTestFeed(t, s, oldURL, 2)
+
+	moved, err := s.MoveFeed(id, newURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != id {
+		t.Errorf("MoveFeed returned %d, want %d", moved, id)
+	}
+	if got := getFeedURLs(t, s); !slices.Equal(got, []string{newURL}) {
+		t.Errorf("got feeds %v, want %v", got, []string{newURL})
+	}
+	if n := len(getEntries(t, s, id)); n != 2 {
+		t.Errorf("moved feed has %d entries, want 2", n)
+	}
+
+	// The old URL stays in the configuration
+	if added, err := s.Import([]string{oldURL}); err != nil || added != 0 {
+		t.Errorf("Import of the old URL added %d, %v; want 0", added, err)
+	}
+
+	if err := s.Unsubscribe(id); err != nil {
+		t.Fatal(err)
+	}
+	if added, err := s.Import([]string{oldURL, newURL}); err != nil || added != 0 {
+		t.Errorf("Import after unsubscribing added %d, %v; want 0", added, err)
+	}
+}
+
+func testMerge(t *testing.T, s Store) {
+	const oldURL, newURL = "https://example.com/old", "https://example.com/new"
+	oldID, _ := addTestFeed(t, s, oldURL, 3)
+	newID, _ := addTestFeed(t, s, newURL, 2)
+
+	// An entry in both feeds, starred and read only in the old one
+	shared := func(feedID int64) *Entry {
+		entry := &Entry{FeedID: feedID, GUID: "shared", Title: "Shared", LastSeen: time.Now()}
+		if err := s.AddEntry(entry, true); err != nil {
+			t.Fatal(err)
+		}
+		return entry
+	}
+	old := shared(oldID)
+	shared(newID)
+	if err := s.SetStarred(old.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetRead(old.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := s.MoveFeed(oldID, newURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != newID {
+		t.Errorf("MoveFeed returned %d, want %d", moved, newID)
+	}
+	if got := getFeedURLs(t, s); !slices.Equal(got, []string{newURL}) {
+		t.Errorf("got feeds %v, want %v", got, []string{newURL})
+	}
+	// Other entries have GUIDs under their feed's URL, so only one is shared
+	entries := getEntries(t, s, newID)
+	if len(entries) != 6 {
+		t.Errorf("merged feed has %d entries, want 6", len(entries))
+	}
+	for _, e := range entries {
+		if e.GUID == "shared" && (!e.Starred || !e.Read) {
+			t.Errorf("shared entry lost its state: starred %v, read %v", e.Starred, e.Read)
+		}
+	}
+}
+
+// Get the titles of the entries matching query, best matches first
+func searchTitles(t *testing.T, s Store, query string) []string {
+	t.Helper()
+	results, err := s.Search(query)
+	if err != nil {
+		t.Fatalf("Search(%q): %v", query, err)
+	}
+	var titles []string
+	for _, r := range results {
+		titles = append(titles, r.Entry.Title)
+	}
+	return titles
+}
+
+func testSearch(t *testing.T, s Store) {
+	const url, otherURL = "https://example.com/feed", "https://example.com/other"
+	updates := testEntries(url, 2)
+	updates[0].Entry.Title, updates[0].Entry.Content = "Rocket launch today", "<p>Nothing to see</p>"
+	updates[1].Entry.Title, updates[1].Entry.Content = "Weather", "<p>The rocket was delayed by rain</p>"
+	if _, err := s.UpdateFeed(&Feed{URL: url}, updates); err != nil {
+		t.Fatal(err)
+	}
+	other := testEntries(otherURL, 1)
+	other[0].Entry.Title = "Nebula photos"
+	otherID, err := s.UpdateFeed(&Feed{URL: otherURL}, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Title matches come first
+	if got, want := searchTitles(t, s, "rocket"), []string{"Rocket launch today", "Weather"}; !slices.Equal(got, want) {
+		t.Errorf("search for rocket found %v, want %v", got, want)
+	}
+	if got, want := searchTitles(t, s, "Nebula"), []string{"Nebula photos"}; !slices.Equal(got, want) {
+		t.Errorf("search for Nebula found %v, want %v", got, want)
+	}
+
+	// The index follows edits
+	updates = testEntries(url, 1)
+	updates[0].Entry.Title = "Comet launch today"
+	if _, err := s.UpdateFeed(&Feed{URL: url}, updates); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := searchTitles(t, s, "rocket"), []string{"Weather"}; !slices.Equal(got, want) {
+		t.Errorf("search for rocket after the edit found %v, want %v", got, want)
+	}
+	if got, want := searchTitles(t, s, "comet"), []string{"Comet launch today"}; !slices.Equal(got, want) {
+		t.Errorf("search for comet found %v, want %v", got, want)
+	}
+
+	// and unsubscribing
+	if err := s.Unsubscribe(otherID); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchTitles(t, s, "nebula"); len(got) != 0 {
+		t.Errorf("search for nebula after unsubscribing found %v", got)
+	}
+
+	// Query syntax characters are searched for, not interpreted
+	for _, query := range []string{`"`, `*`, `rocket"`, `"rocket*`, `a OR b`, `NEAR(x`, `title:x`, "", "  "} {
+		searchTitles(t, s, query)
+	}
+}
@@ -0,0 +1,196 @@
+package feed
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Subscribe to the feed at url unless already subscribed. Returns the feed's ID.
+func (s *SQLiteStore) Subscribe(url string) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Subscribing again undoes an earlier unsubscribe
+	if _, err = tx.Exec("DELETE FROM unsubscribed WHERE url = ?", url); err != nil {
+		return 0, err
+	}
+
+	id, _, err := subscribe(tx, url)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// Add a feed with only a URL if there is no feed with that URL, or that
+// moved from it. Returns the feed's ID and whether it was added.
+func subscribe(tx *sql.Tx, url string) (int64, bool, error) {
+	var id int64
+	err := tx.QueryRow(`SELECT id FROM feeds WHERE url = ?
+		UNION ALL SELECT f.id FROM moved_feeds m JOIN feeds f ON f.id = m.feed_id WHERE m.url = ?`, url, url).Scan(&id)
+	if err == nil {
+		return id, false, nil
+	} else if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+
+	log.Println("Subscribing to", url)
+	res, err := tx.Exec("INSERT INTO feeds (url, title, description) VALUES (?, '', '')", url)
+	if err != nil {
+		return 0, false, err
+	}
+	id, err = res.LastInsertId()
+	return id, true, err
+}
+
+// Subscribe to each of urls that is neither subscribed to nor was unsubscribed from
+func (s *SQLiteStore) Import(urls []string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	added := 0
+	for _, url := range urls {
+		var removed bool
+		err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM unsubscribed WHERE url = ?)", url).Scan(&removed)
+		if err != nil {
+			return 0, err
+		}
+		if removed {
+			continue
+		}
+
+		_, ok, err := subscribe(tx, url)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			added++
+		}
+	}
+	return added, tx.Commit()
+}
+
+// Delete a feed and all its entries, and remember its URL so that Import
+// does not subscribe to it again
+func (s *SQLiteStore) Unsubscribe(feedID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var url string
+	err = tx.QueryRow("SELECT url FROM feeds WHERE id = ?", feedID).Scan(&url)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	log.Println("Unsubscribing from", url)
+	if _, err = tx.Exec("DELETE FROM entries WHERE feed_id = ?", feedID); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM feeds WHERE id = ?", feedID); err != nil {
+		return err
+	}
+	// Also the URLs it moved from, so that importing them doesn't bring it back
+	now := time.Now().Unix()
+	_, err = tx.Exec("INSERT OR REPLACE INTO unsubscribed (url, removed) VALUES (?, ?)", url, now)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT OR REPLACE INTO unsubscribed (url, removed)
+		SELECT url, ? FROM moved_feeds WHERE feed_id = ?`, now, feedID)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM moved_feeds WHERE feed_id = ?", feedID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Change the URL of a feed that moved permanently, merging it into the feed
+// already at url if there is one
+func (s *SQLiteStore) MoveFeed(feedID int64, url string) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var oldURL string
+	err = tx.QueryRow("SELECT url FROM feeds WHERE id = ?", feedID).Scan(&oldURL)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if oldURL == url {
+		return feedID, nil
+	}
+
+	target := feedID
+	err = tx.QueryRow("SELECT id FROM feeds WHERE url = ?", url).Scan(&target)
+	switch {
+	case err == sql.ErrNoRows:
+		log.Println("Moving", oldURL, "to", url)
+		if _, err = tx.Exec("UPDATE feeds SET url = ? WHERE id = ?", url, feedID); err != nil {
+			return 0, err
+		}
+	case err != nil:
+		return 0, err
+	default:
+		// Already subscribed to the new URL: keep the entries it does not have
+		log.Println("Merging", oldURL, "into", url)
+		stmts := []string{
+			"UPDATE OR IGNORE entries SET feed_id = ? WHERE feed_id = ?",
+			// Entries left behind are in both feeds: keep them starred and read
+			`UPDATE entries AS t SET read = MAX(t.read, o.read), starred = MAX(t.starred, o.starred)
+				FROM entries AS o WHERE t.feed_id = ? AND o.feed_id = ? AND o.guid = t.guid`,
+			"UPDATE moved_feeds SET feed_id = ? WHERE feed_id = ?",
+		}
+		for _, stmt := range stmts {
+			if _, err = tx.Exec(stmt, target, feedID); err != nil {
+				return 0, err
+			}
+		}
+		if _, err = tx.Exec("DELETE FROM entries WHERE feed_id = ?", feedID); err != nil {
+			return 0, err
+		}
+		if _, err = tx.Exec("DELETE FROM feeds WHERE id = ?", feedID); err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec("INSERT OR REPLACE INTO moved_feeds (url, feed_id, moved) VALUES (?, ?, ?)",
+		oldURL, target, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	// The feed may be moving back to where it was
+	if _, err = tx.Exec("DELETE FROM moved_feeds WHERE url = ?", url); err != nil {
+		return 0, err
+	}
+	return target, tx.Commit()
+}
+
+// Set the title shown for a feed, or "" to show the feed's own title
+func (s *SQLiteStore) RenameFeed(feedID int64, title string) error {
+	_, err := s.db.Exec("UPDATE feeds SET custom_title = ? WHERE id = ?", title, feedID)
+	return err
+}
+
+// Pause or resume syncing a feed
+func (s *SQLiteStore) SetPaused(feedID int64, paused bool) error {
+	_, err := s.db.Exec("UPDATE feeds SET paused = ? WHERE id = ?", paused, feedID)
+	return err
+}
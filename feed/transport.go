@@ -0,0 +1,114 @@
+package feed
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bmoneill/sreader/config"
+)
+
+// Connection settings that need a transport of their own
+type transportSettings struct {
+	proxy      string
+	caFile     string
+	clientCert string
+	clientKey  string
+}
+
+// HTTP clients for fetching feeds, one per set of connection settings, so
+// that feeds with the same settings share connections
+type httpClients struct {
+	mu      sync.Mutex
+	clients map[transportSettings]*http.Client
+}
+
+func newHTTPClients() *httpClients {
+	return &httpClients{clients: make(map[transportSettings]*http.Client)}
+}
+
+// Get the client to fetch the feed at feedURL with
+func (c *httpClients) get(feedURL string) (*http.Client, error) {
+	var settings transportSettings
+	settings.proxy, settings.caFile, settings.clientCert, settings.clientKey = config.Config.Connection(feedURL)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	client := c.clients[settings]
+	if client == nil {
+		transport, err := newTransport(settings)
+		if err != nil {
+			return nil, err
+		}
+		client = &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(config.Config.Timeout) * time.Second,
+		}
+		c.clients[settings] = client
+	}
+	return withHeaderRedirects(withCookies(client, feedURL), feedURL), nil
+}
+
+// Get a transport with the configured timeouts, proxy and TLS settings
+func newTransport(settings transportSettings) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	connectTimeout := time.Duration(config.Config.ConnectTimeout) * time.Second
+	transport.DialContext = (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+
+	// Without a proxy setting, the default transport's proxy from the
+	// environment (HTTP_PROXY, HTTPS_PROXY and NO_PROXY) is used
+	switch settings.proxy {
+	case "":
+	case "direct":
+		transport.Proxy = nil
+	default:
+		proxy, err := url.Parse(settings.proxy)
+		if err != nil || proxy.Scheme == "" || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q", settings.proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if settings.caFile == "" && settings.clientCert == "" {
+		return transport, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if settings.caFile != "" {
+		// Trust the bundle's certificates as well as the system's
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(config.ExpandHome(settings.caFile))
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", settings.caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if settings.clientCert != "" {
+		// The key may be in the certificate's file
+		keyFile := settings.clientKey
+		if keyFile == "" {
+			keyFile = settings.clientCert
+		}
+		cert, err := tls.LoadX509KeyPair(config.ExpandHome(settings.clientCert), config.ExpandHome(keyFile))
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
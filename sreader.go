@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
@@ -19,21 +20,85 @@ func main() {
 
 	// Parse command line flags
 	confFlag := flag.String("c", confPath, "Path to the configuration file")
-	syncFlag := flag.Bool("s", false, "Sync feeds and exit")
+	syncFlag := flag.Bool("s", false, "Sync feeds that are due and exit")
+	forceFlag := flag.Bool("f", false, "With -s, sync all feeds, due or not")
+	pruneFlag := flag.Bool("p", false, "Prune old entries and exit")
+	addFlag := flag.String("a", "", "Subscribe to a feed URL and exit")
 	flag.Parse()
+	if cmd := flag.Arg(0); cmd != "" && cmd != "daemon" {
+		log.Fatalln("Unknown command:", cmd)
+	}
 
 	config.LoadConfig(*confFlag)
-	feed.InitDB()
+	store, err := feed.OpenSQLiteStore(config.Config.DBFile)
+	if err != nil {
+		log.Fatalln("Failed to load database:", err.Error())
+	}
+	defer store.Close()
+
+	if err := feed.ImportConfig(store); err != nil {
+		log.Fatalln("Failed to import feed URLs:", err.Error())
+	}
+
+	// sync feeds as they become due until stopped if called as "sreader daemon"
+	if flag.Arg(0) == "daemon" {
+		reload := func() error {
+			if err := config.ReloadConfig(*confFlag); err != nil {
+				return err
+			}
+			return feed.ImportConfig(store)
+		}
+		if err := feed.Daemon(store, reload); err != nil {
+			log.Fatalln("Daemon failed:", err.Error())
+		}
+		return
+	}
+
+	// subscribe and quit if called with "-a" flag
+	if *addFlag != "" {
+		feeds, err := feed.Discover(*addFlag)
+		if err != nil {
+			log.Fatalln("No feed found at", *addFlag+":", err.Error())
+		}
+		if len(feeds) > 1 {
+			log.Println("Found several feeds, run again with the one to subscribe to:")
+			for _, f := range feeds {
+				log.Println(" ", f.URL, f.Title)
+			}
+			os.Exit(1)
+		}
+
+		url := feeds[0].URL
+		if _, err := store.Subscribe(url); err != nil {
+			log.Fatalln("Failed to subscribe:", err.Error())
+		}
+		if err := feed.SyncFeed(context.Background(), store, url); err != nil {
+			log.Println("Subscribed, but not synced:", err.Error())
+		}
+		return
+	}
 
 	// sync and quit if called with "-s" flag
 	if *syncFlag {
-		feed.Sync()
+		if err := feed.Sync(context.Background(), store, *forceFlag); err == feed.ErrSyncRunning {
+			log.Println("Another sync is already running, skipping.")
+		} else if err != nil {
+			log.Fatalln("Failed to sync:", err.Error())
+		}
+		return
+	}
+
+	// prune and quit if called with "-p" flag
+	if *pruneFlag {
+		if err := feed.Prune(store); err != nil {
+			log.Fatalln("Failed to prune entries:", err.Error())
+		}
 		return
 	}
 
-	feeds := feed.GetFeeds()
+	feeds := feed.GetFeeds(store)
 
-	ui := ui.Init(feeds)
+	ui := ui.Init(store, feeds)
 
 	writer, err := os.Create(config.ExpandHome(config.Config.LogFile))
 	if err != nil {
@@ -0,0 +1,134 @@
+package ui
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	addedStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	removedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+)
+
+// Lines of unchanged text shown around each change
+const diffContext = 3
+
+// Largest number of line pairs compared to find the changes in a text.
+// Longer texts are shown as all removed and then all added.
+const maxDiffCells = 1 << 20
+
+// A line of a diff
+type diffLine struct {
+	op   byte // ' ', '-' or '+'
+	text string
+}
+
+// Compares two texts line by line and renders the changes, prefixing removed
+// lines with "-" and added lines with "+". Unchanged lines far from any change
+// are left out.
+func diffText(oldText, newText string) string {
+	a := strings.Split(oldText, "\n")
+	b := strings.Split(newText, "\n")
+
+	// Only the lines between the common start and end can have changed
+	prefix := 0
+	for prefix < min(len(a), len(b)) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < min(len(a), len(b))-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var lines []diffLine
+	for _, text := range a[:prefix] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	lines = append(lines, diffLines(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, text := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{' ', text})
+	}
+
+	// Keep changed lines and the context around them
+	keep := make([]bool, len(lines))
+	for n, line := range lines {
+		if line.op == ' ' {
+			continue
+		}
+		for k := max(0, n-diffContext); k <= min(len(lines)-1, n+diffContext); k++ {
+			keep[k] = true
+		}
+	}
+
+	var sb strings.Builder
+	skipped := false
+	for n, line := range lines {
+		if !keep[n] {
+			skipped = true
+			continue
+		}
+		if skipped {
+			sb.WriteString("...\n")
+			skipped = false
+		}
+		switch line.op {
+		case '+':
+			sb.WriteString(addedStyle.Render("+ "+line.text) + "\n")
+		case '-':
+			sb.WriteString(removedStyle.Render("- "+line.text) + "\n")
+		default:
+			sb.WriteString("  " + line.text + "\n")
+		}
+	}
+	if skipped {
+		sb.WriteString("...\n")
+	}
+	return sb.String()
+}
+
+// Get the changes from lines a to lines b
+func diffLines(a, b []string) []diffLine {
+	var lines []diffLine
+	if len(a)*len(b) > maxDiffCells {
+		for _, text := range a {
+			lines = append(lines, diffLine{'-', text})
+		}
+		for _, text := range b {
+			lines = append(lines, diffLine{'+', text})
+		}
+		return lines
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	return lines
+}
@@ -1,18 +1,31 @@
 package ui
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
 
 	html2markdown "github.com/JohannesKaufmann/html-to-markdown/v2"
 	"github.com/bmoneill/sreader/config"
 	"github.com/bmoneill/sreader/feed"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
 
-const titlestr = "sreader: "
+const (
+	titlestr       = "sreader: "
+	entryListTitle = "Entries"
+
+	// Interval between reads of the status file of "sreader daemon"
+	daemonCheckInterval = 30 * time.Second
+)
 
 type viewState int
 
@@ -21,6 +34,20 @@ const (
 	feedListView viewState = iota
 	entryListView
 	entryView
+	diffView
+	feedInfoView
+	discoverView
+)
+
+type promptKind int
+
+// Kinds of input read with the prompt
+const (
+	noPrompt promptKind = iota
+	searchPrompt
+	addPrompt
+	renamePrompt
+	removePrompt
 )
 
 var (
@@ -39,15 +66,52 @@ func (f feedItem) Description() string { return f.desc }
 func (f feedItem) FilterValue() string { return f.title }
 
 type model struct {
-	feeds     []*feed.Feed
-	view      viewState
-	feedList  list.Model
-	entryList list.Model
-	entry     viewport.Model
-	currFeed  int
-	currEntry int
-	width     int
-	height    int
+	store          feed.Store
+	feeds          []*feed.Feed
+	entries        []*feed.Entry          // Entries shown in entryList
+	discovered     []*feed.DiscoveredFeed // Feeds shown in discoverList
+	view           viewState
+	feedList       list.Model
+	entryList      list.Model
+	discoverList   list.Model // Feeds found on a page being subscribed to
+	entry          viewport.Model
+	prompt         textinput.Model
+	prompting      promptKind
+	promptFeed     *feed.Feed // Feed renamed or removed by the prompt
+	showingResults bool       // Whether entryList shows search results
+	status         string     // Message shown until the next key press
+	currFeed       int
+	currEntry      int
+	revisions      []*feed.Revision // Earlier versions of the entry shown in diffView
+	currRevision   int
+	width          int
+	height         int
+	daemon         *feed.DaemonStatus // Status of "sreader daemon", nil if it never ran
+	daemonSynced   time.Time          // When feedList last showed the daemon's syncs
+	activity       string             // What runs in the background, "" if nothing does
+	cancelSync     context.CancelFunc // Cancels the sync running in the background, if any
+	syncGen        int                // Number of the latest sync started with startSync
+}
+
+// Carries the status of "sreader daemon" read from its status file
+type daemonStatusMsg struct {
+	status *feed.DaemonStatus
+}
+
+// Reports the end of a sync started with startSync
+type syncDoneMsg struct {
+	gen      int                // Number of the sync, from model.syncGen
+	cancel   context.CancelFunc // Releases the sync's context
+	err      error
+	failed   string // Status shown before the error if the sync failed
+	selected int64  // ID of the feed to select, 0 to keep the selection
+}
+
+// Carries the feeds found by a discovery started with discover
+type discoveredMsg struct {
+	url   string
+	feeds []*feed.DiscoveredFeed
+	err   error
 }
 
 // Handles user input and updates the model accordingly
@@ -55,18 +119,65 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
 
 	switch msg := msg.(type) {
+	case daemonStatusMsg:
+		m.updateDaemonStatus(msg.status)
+		return m, tea.Tick(daemonCheckInterval, func(time.Time) tea.Msg { return readDaemonStatus() })
+	case syncDoneMsg:
+		msg.cancel()
+		if msg.gen == m.syncGen {
+			m.cancelSync, m.activity = nil, ""
+		}
+		switch {
+		case errors.Is(msg.err, context.Canceled):
+			m.status = "Sync cancelled."
+		case msg.err != nil:
+			m.status = msg.failed + msg.err.Error()
+		}
+		m.reloadSynced(msg.selected)
+		return m, nil
+	case discoveredMsg:
+		m.activity = ""
+		return m, m.showDiscovered(msg.url, msg.feeds, msg.err)
 	case tea.WindowSizeMsg:
 		m.width, m.height = msg.Width, msg.Height
 		m.feedList.SetSize(msg.Width, msg.Height)
 		m.entryList.SetSize(msg.Width, msg.Height)
+		m.discoverList.SetSize(msg.Width, msg.Height)
 		m.entry.Width = msg.Width
 		m.entry.Height = msg.Height
 	case tea.KeyMsg:
+		m.status = ""
+
+		// Ctrl-C cancels a running sync instead of quitting
+		if msg.Type == tea.KeyCtrlC && m.cancelSync != nil {
+			m.cancelSync()
+			m.status = "Cancelling sync..."
+			return m, nil
+		}
+
 		// Let bubbletea handle filtering
 		if m.entryList.FilterState() == list.Filtering || m.feedList.FilterState() == list.Filtering {
 			break
 		}
 
+		// The prompt takes all input until submitted or cancelled
+		if m.prompting != noPrompt {
+			switch msg.Type {
+			case tea.KeyEnter:
+				kind := m.prompting
+				m.prompting = noPrompt
+				m.prompt.Blur()
+				return m, m.submitPrompt(kind, m.prompt.Value())
+			case tea.KeyEsc:
+				m.prompting = noPrompt
+				m.prompt.Blur()
+				return m, nil
+			}
+			var cmd tea.Cmd
+			m.prompt, cmd = m.prompt.Update(msg)
+			return m, cmd
+		}
+
 		switch msg.String() {
 		case config.Config.QuitKey:
 			return m, tea.Quit
@@ -80,17 +191,34 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case entryView:
 				m.view = entryListView
 				m.currEntry = 0
+			case diffView:
+				m.updateEntryView()
+				m.view = entryView
+			case feedInfoView, discoverView:
+				m.view = feedListView
 			}
 		case config.Config.RightKey:
 			switch m.view {
 			case feedListView:
-				m.currFeed = m.feedList.Index()
+				m.currFeed = m.feedList.GlobalIndex()
 				m.updateEntryList()
 				m.view = entryListView
 			case entryListView:
-				m.currEntry = m.entryList.Index()
+				m.currEntry = m.entryList.GlobalIndex()
+				if m.currEntry < len(m.entries) && !m.entries[m.currEntry].Read {
+					m.setRead(m.entries[m.currEntry], true)
+				}
 				m.updateEntryView()
 				m.view = entryView
+			case discoverView:
+				if m.activity != "" {
+					m.status = "Busy: " + m.activity
+					return m, nil
+				}
+				m.view = feedListView
+				if i := m.discoverList.GlobalIndex(); i < len(m.discovered) {
+					return m, m.subscribe(m.discovered[i].URL)
+				}
 			}
 		case config.Config.DownKey:
 			switch m.view {
@@ -98,7 +226,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.feedList, _ = m.feedList.Update(msg)
 			case entryListView:
 				m.entryList, _ = m.entryList.Update(msg)
-			case entryView:
+			case discoverView:
+				m.discoverList, _ = m.discoverList.Update(msg)
+			case entryView, diffView, feedInfoView:
 				m.entry.ScrollDown(1)
 			}
 			return m, nil
@@ -108,33 +238,123 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.feedList, _ = m.feedList.Update(msg)
 			case entryListView:
 				m.entryList, _ = m.entryList.Update(msg)
-			case entryView:
+			case discoverView:
+				m.discoverList, _ = m.discoverList.Update(msg)
+			case entryView, diffView, feedInfoView:
 				m.entry.ScrollUp(1)
 			}
 			return m, nil
-		case config.Config.SyncKey:
-			feed.Sync()
-			m.feeds = feed.GetFeeds()
-			switch m.view {
-			case feedListView:
-				m.updateFeedList()
-			case entryListView:
-				m.updateEntryList()
+		case config.Config.SyncKey, config.Config.ForceSyncKey:
+			// Sync the feeds that are due, or all of them when forced
+			if m.activity != "" {
+				m.status = "Busy: " + m.activity
+				return m, nil
 			}
-			m.updateEntryList()
-			return m, nil
+			force := msg.String() == config.Config.ForceSyncKey
+			store := m.store
+			return m, m.startSync("syncing", "Sync failed: ", 0, func(ctx context.Context) error {
+				return feed.Sync(ctx, store, force)
+			})
 		case config.Config.BrowserKey:
-			if m.view == entryListView || m.view == entryView {
-				link := m.feeds[m.currFeed].Entries[m.currEntry].URL
+			if m.view == feedListView {
+				// Open the feed's homepage, or the feed itself if it has none
+				if i := m.feedList.GlobalIndex(); i < len(m.feeds) && m.feeds[i].ID != feed.StarredFeedID {
+					link := m.feeds[i].SiteURL
+					if link == "" {
+						link = m.feeds[i].URL
+					}
+					feed.OpenInBrowser(link, config.Config.Browser)
+				}
+			} else if m.currEntry < len(m.entries) {
+				link := m.entries[m.currEntry].URL
 				feed.OpenInBrowser(link, config.Config.Browser)
 			}
 			return m, nil
 		case config.Config.PlayerKey:
-			if m.view == entryListView || m.view == entryView {
-				link := m.feeds[m.currFeed].Entries[m.currEntry].URL
+			if (m.view == entryListView || m.view == entryView) && m.currEntry < len(m.entries) {
+				link := m.entries[m.currEntry].URL
 				feed.OpenInPlayer(link, config.Config.Player)
 			}
 			return m, nil
+		case config.Config.StarKey:
+			if m.view == entryListView {
+				m.currEntry = m.entryList.GlobalIndex()
+			}
+			if (m.view == entryListView || m.view == entryView) && m.currEntry < len(m.entries) {
+				m.toggleStarred(m.entries[m.currEntry])
+			}
+			return m, nil
+		case config.Config.ReadKey:
+			if m.view == entryListView {
+				m.currEntry = m.entryList.GlobalIndex()
+			}
+			if (m.view == entryListView || m.view == entryView) && m.currEntry < len(m.entries) {
+				entry := m.entries[m.currEntry]
+				m.setRead(entry, !entry.Read)
+			}
+			return m, nil
+		case config.Config.ReadAllKey:
+			switch m.view {
+			case feedListView:
+				if i := m.feedList.GlobalIndex(); i < len(m.feeds) {
+					m.markFeedRead(m.feeds[i])
+				}
+			case entryListView:
+				if m.showingResults {
+					m.markEntriesRead(m.entries)
+				} else if m.currFeed < len(m.feeds) {
+					m.markFeedRead(m.feeds[m.currFeed])
+				}
+			}
+			return m, nil
+		case config.Config.DiffKey:
+			switch m.view {
+			case entryView:
+				if m.currEntry < len(m.entries) && m.loadRevisions(m.entries[m.currEntry]) {
+					m.currRevision = 0
+					m.updateDiffView()
+					m.view = diffView
+				}
+			case diffView:
+				// Step back to the next older version, wrapping around
+				m.currRevision = (m.currRevision + 1) % len(m.revisions)
+				m.updateDiffView()
+			}
+			return m, nil
+		case config.Config.SearchKey:
+			if m.view == feedListView || m.view == entryListView {
+				return m, m.startPrompt(searchPrompt, "Search: ", "", nil)
+			}
+			return m, nil
+		case config.Config.AddKey:
+			if m.view == feedListView && m.activity != "" {
+				m.status = "Busy: " + m.activity
+			} else if m.view == feedListView {
+				return m, m.startPrompt(addPrompt, "Subscribe to URL: ", "", nil)
+			}
+			return m, nil
+		case config.Config.RemoveKey:
+			if f := m.selectedFeed(); f != nil {
+				return m, m.startPrompt(removePrompt, "Unsubscribe from "+f.DisplayTitle()+
+					" and delete its entries? [y/N] ", "", f)
+			}
+			return m, nil
+		case config.Config.RenameKey:
+			if f := m.selectedFeed(); f != nil {
+				return m, m.startPrompt(renamePrompt, "Rename feed (empty to reset): ", f.DisplayTitle(), f)
+			}
+			return m, nil
+		case config.Config.InfoKey:
+			if f := m.selectedFeed(); f != nil {
+				m.updateFeedInfoView(f)
+				m.view = feedInfoView
+			}
+			return m, nil
+		case config.Config.PauseKey:
+			if f := m.selectedFeed(); f != nil {
+				m.setPaused(f, !f.Paused)
+			}
+			return m, nil
 		case config.Config.FilterKey:
 			switch m.view {
 			case feedListView:
@@ -167,7 +387,11 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		newEntryListModel, cmd := m.entryList.Update(msg)
 		m.entryList = newEntryListModel
 		cmds = append(cmds, cmd)
-	case entryView:
+	case discoverView:
+		newDiscoverListModel, cmd := m.discoverList.Update(msg)
+		m.discoverList = newDiscoverListModel
+		cmds = append(cmds, cmd)
+	case entryView, diffView, feedInfoView:
 		newEntryModel, cmd := m.entry.Update(msg)
 		m.entry = newEntryModel
 		cmds = append(cmds, cmd)
@@ -178,28 +402,43 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 // Renders the current view of the model.
 func (m model) View() string {
-	s := fmt.Sprintf("%s\n\n", titlestr)
+	s := fmt.Sprintf("%s%s\n\n", titlestr, m.headerText())
+	if m.prompting != noPrompt {
+		s += m.prompt.View() + "\n\n"
+	}
 	switch m.view {
 	case feedListView:
 		s += m.feedList.View()
 	case entryListView:
 		s += m.entryList.View()
-	case entryView:
+	case discoverView:
+		s += m.discoverList.View()
+	case entryView, diffView, feedInfoView:
 		s += m.entry.View()
 	}
 
+	if m.status != "" {
+		s += "\n" + m.status
+	}
+
 	// Controls helper
 	s += "\n[" + config.Config.LeftKey + "] back [" + config.Config.RightKey +
 		"] enter [" + config.Config.DownKey + "/" + config.Config.UpKey +
-		"] move [" + config.Config.QuitKey + "] quit [" + config.Config.SyncKey +
-		"] sync [" + config.Config.BrowserKey + "] open [" + config.Config.PlayerKey + "] play"
+		"] move [" + config.Config.QuitKey + "] quit [" + config.Config.SyncKey + "/" + config.Config.ForceSyncKey +
+		"] sync/all [" + config.Config.BrowserKey + "] open [" + config.Config.PlayerKey + "] play [" +
+		config.Config.StarKey + "] star [" + config.Config.ReadKey + "/" + config.Config.ReadAllKey +
+		"] read/all read [" + config.Config.SearchKey + "] search [" + config.Config.DiffKey + "] changes"
+	if m.view == feedListView {
+		s += " [" + config.Config.AddKey + "/" + config.Config.RemoveKey + "/" + config.Config.RenameKey +
+			"/" + config.Config.PauseKey + "] add/remove/rename/pause [" + config.Config.InfoKey + "] details"
+	}
 
 	// Render the entire UI with the app style
 	return appStyle.Render(lipgloss.Place(m.width, m.height, lipgloss.Left, lipgloss.Top, s))
 }
 
-// Initializes the UI with the given feeds and configuration.
-func Init(feeds []*feed.Feed) *tea.Program {
+// Initializes the UI with the given store, feeds and configuration.
+func Init(store feed.Store, feeds []*feed.Feed) *tea.Program {
 	width, height := 500, 24 // width set to 500, hopefully enough for most screens
 
 	// Styles
@@ -233,7 +472,7 @@ func Init(feeds []*feed.Feed) *tea.Program {
 		Background(selectedDescBG).
 		Width(width)
 
-	m := newModel(feeds, width, height)
+	m := newModel(store, feeds, width, height)
 	m.feedList.SetDelegate(listDelegate)
 	m.feedList.SetShowTitle(true)
 	m.feedList.SetShowFilter(true)
@@ -249,7 +488,68 @@ func Init(feeds []*feed.Feed) *tea.Program {
 }
 
 func (m model) Init() tea.Cmd {
-	return nil
+	return readDaemonStatus
+}
+
+// Reads the status file of "sreader daemon"
+func readDaemonStatus() tea.Msg {
+	status, err := feed.ReadDaemonStatus()
+	if err != nil {
+		log.Println("Failed to read daemon status:", err.Error())
+	}
+	return daemonStatusMsg{status}
+}
+
+// Keeps the latest daemon status and, in feedList, shows the feeds it synced
+// since the last check.
+func (m *model) updateDaemonStatus(status *feed.DaemonStatus) {
+	m.daemon = status
+	if status == nil || !status.LastSync.After(m.daemonSynced) {
+		return
+	}
+
+	if m.view == feedListView && m.prompting == noPrompt && m.feedList.FilterState() == list.Unfiltered {
+		var selected int64
+		if i := m.feedList.GlobalIndex(); i < len(m.feeds) {
+			selected = m.feeds[i].ID
+		}
+		m.reloadFeeds(selected)
+		m.daemonSynced = status.LastSync
+	}
+}
+
+// Describes what runs in the background here and in "sreader daemon"
+func (m model) headerText() string {
+	var parts []string
+	if m.activity != "" {
+		parts = append(parts, m.activity)
+	}
+	if text := m.daemonText(); text != "" {
+		parts = append(parts, text)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Describes what "sreader daemon" is doing, if it is running
+func (m model) daemonText() string {
+	if m.daemon == nil || !m.daemon.Running() {
+		return ""
+	}
+
+	text := "daemon idle"
+	switch {
+	case m.daemon.Syncing:
+		text = "daemon syncing"
+	case !m.daemon.LastSync.IsZero():
+		text = "daemon synced " + m.daemon.LastSync.Local().Format("15:04")
+	}
+	if m.daemon.NextSync.After(time.Now()) {
+		text += ", next " + m.daemon.NextSync.Local().Format("15:04")
+	}
+	if m.daemon.LastError != "" {
+		text += " (error: " + m.daemon.LastError + ")"
+	}
+	return text
 }
 
 // Converts HTML to plain text and wraps lines at the specified width.
@@ -293,11 +593,17 @@ func htmlTruncate(content string, width int) string {
 	return string(result)
 }
 
+// Prepends the Starred pseudo-feed to feeds
+func (m *model) withStarred(feeds []*feed.Feed) []*feed.Feed {
+	return append([]*feed.Feed{feed.GetStarredFeed(m.store)}, feeds...)
+}
+
 // Initializes the model
-func newModel(feeds []*feed.Feed, width, height int) model {
+func newModel(store feed.Store, feeds []*feed.Feed, width, height int) model {
+	feeds = append([]*feed.Feed{feed.GetStarredFeed(store)}, feeds...)
 	feedItems := make([]list.Item, len(feeds))
 	for i, f := range feeds {
-		feedItems[i] = feedItem{title: f.Title, desc: f.Description, link: f.URL}
+		feedItems[i] = newFeedItem(f)
 	}
 
 	feedList := list.New(feedItems, list.NewDefaultDelegate(), width, height)
@@ -305,11 +611,17 @@ func newModel(feeds []*feed.Feed, width, height int) model {
 
 	entryItems := []list.Item{}
 	entryList := list.New(entryItems, list.NewDefaultDelegate(), width, height)
-	entryList.Title = "Entries"
+	entryList.Title = entryListTitle
+
+	discoverList := list.New([]list.Item{}, list.NewDefaultDelegate(), width, height)
+	discoverList.Title = "Choose a feed"
 
 	// Hide duplicated keybind help strings (we implement our own)
 	feedList.SetShowHelp(false)
 	entryList.SetShowHelp(false)
+	discoverList.SetShowHelp(false)
+
+	prompt := textinput.New()
 
 	vp := viewport.New(width, height)
 	if len(feeds) > 0 && len(feeds[0].Entries) > 0 {
@@ -317,29 +629,37 @@ func newModel(feeds []*feed.Feed, width, height int) model {
 	}
 
 	return model{
-		feeds:     feeds,
-		view:      feedListView,
-		feedList:  feedList,
-		entryList: entryList,
-		entry:     vp,
-		currFeed:  0,
-		currEntry: 0,
-		width:     width,
-		height:    height,
+		store:        store,
+		feeds:        feeds,
+		view:         feedListView,
+		feedList:     feedList,
+		entryList:    entryList,
+		discoverList: discoverList,
+		entry:        vp,
+		prompt:       prompt,
+		currFeed:     0,
+		currEntry:    0,
+		width:        width,
+		height:       height,
+		daemonSynced: time.Now(), // The feeds were just loaded
 	}
 }
 
 // In entryList, updates the list of entries based on the currently selected feed.
 func (m *model) updateEntryList() {
 	entryItems := []list.Item{}
+	m.entries = nil
 	if m.currFeed < len(m.feeds) {
-		for _, item := range m.feeds[m.currFeed].Entries {
+		m.entries = m.feeds[m.currFeed].Entries
+		for _, item := range m.entries {
 			entryItems = append(entryItems, feedItem{
-				title: item.Title,
+				title: entryTitle(item),
 				link:  item.URL,
 			})
 		}
 	}
+	m.entryList.Title = entryListTitle
+	m.showingResults = false
 	m.entryList.SetItems(entryItems)
 	m.entryList.SetDelegate(listDelegate)
 	m.entryList.Select(0)
@@ -348,25 +668,480 @@ func (m *model) updateEntryList() {
 
 // In entryView, updates the viewport with the content of the currently selected entry.
 func (m *model) updateEntryView() {
-	if m.currFeed < len(m.feeds) && m.currEntry < len(m.feeds[m.currFeed].Entries) {
+	if m.currEntry < len(m.entries) {
+		entry := m.entries[m.currEntry]
+
 		// Set the content to the selected entry's content
-		content := "\nDate: " + m.feeds[m.currFeed].Entries[m.currEntry].DatePublished
-		content += "\nLink: " + m.feeds[m.currFeed].Entries[m.currEntry].URL
-		content += "\n\n" + htmlTruncate(m.feeds[m.currFeed].Entries[m.currEntry].Description, m.width-2)
-		content += "\n\n" + htmlTruncate(m.feeds[m.currFeed].Entries[m.currEntry].Content, m.width-2)
+		content := "\nDate: " + formatDate(entry.Published)
+		content += "\nLink: " + entry.URL
+		if len(entry.Authors) > 0 {
+			content += "\nAuthor: " + formatAuthors(entry.Authors)
+		}
+		if len(entry.Categories) > 0 {
+			content += "\nCategories: " + strings.Join(entry.Categories, ", ")
+		}
+		for _, enclosure := range entry.Enclosures {
+			content += "\nAttachment: " + enclosure.URL
+			if enclosure.Type != "" {
+				content += " (" + enclosure.Type + ")"
+			}
+		}
+		if entry.Revisions > 0 {
+			content += fmt.Sprintf("\nEdited: %d earlier version(s), press %s to see changes",
+				entry.Revisions, config.Config.DiffKey)
+		}
+		content += "\n\n" + htmlTruncate(entry.Description, m.width-2)
+		content += "\n\n" + htmlTruncate(entry.Content, m.width-2)
 		m.entry.SetContent(content)
 		m.entry.GotoTop()
 	}
 }
 
+// In feedInfoView, shows the metadata and sync status of a feed.
+func (m *model) updateFeedInfoView(f *feed.Feed) {
+	type field struct{ name, value string }
+	fields := []field{
+		{"URL", f.URL},
+		{"Homepage", f.SiteURL},
+		{"Title", f.Title},
+		{"Description", f.Description},
+		{"Author", f.Author},
+		{"Language", f.Language},
+		{"Generator", f.Generator},
+		{"Entries", fmt.Sprintf("%d (%d unread)", len(f.Entries), f.UnreadCount())},
+		{"Paused", fmt.Sprint(f.Paused)},
+		{"Gone", fmt.Sprint(f.Gone)},
+		{"Last sync", formatDate(f.LastAttempt)},
+		{"Last success", formatDate(f.LastUpdated)},
+	}
+	if f.TTL > 0 {
+		fields = append(fields, field{"TTL", fmt.Sprintf("%d minutes", f.TTL)})
+	}
+	if len(f.SkipHours) > 0 || len(f.SkipDays) > 0 {
+		fields = append(fields, field{"Skips", fmt.Sprintf("hours %v, days %v (UTC)", f.SkipHours, f.SkipDays)})
+	}
+	next := "now"
+	if f.NextSync.After(time.Now()) {
+		next = formatDate(f.NextSync)
+	}
+	fields = append(fields, field{"Next sync", next})
+	if f.Unchanged > 0 {
+		fields = append(fields, field{"Syncs without news", fmt.Sprint(f.Unchanged)})
+	}
+	if f.HTTPStatus != 0 {
+		fields = append(fields, field{"HTTP status", fmt.Sprintf("%d %s", f.HTTPStatus, http.StatusText(f.HTTPStatus))})
+	}
+	if f.Failures > 0 {
+		fields = append(fields, field{"Failed syncs", fmt.Sprint(f.Failures)}, field{"Last error", f.LastError})
+	}
+
+	content := "\n" + f.DisplayTitle() + "\n"
+	for _, fl := range fields {
+		if fl.value != "" {
+			content += "\n" + fl.name + ": " + fl.value
+		}
+	}
+	m.entry.SetContent(content)
+	m.entry.GotoTop()
+}
+
+// Loads the earlier versions of an entry for diffView.
+// Returns false if there are none.
+func (m *model) loadRevisions(entry *feed.Entry) bool {
+	revisions, err := m.store.GetRevisions(entry.ID)
+	if err != nil {
+		log.Println("Failed to load revisions:", err.Error())
+		return false
+	}
+	m.revisions = revisions
+	return len(revisions) > 0
+}
+
+// In diffView, shows the changes from the current revision to the version
+// that replaced it.
+func (m *model) updateDiffView() {
+	if m.currEntry >= len(m.entries) || m.currRevision >= len(m.revisions) {
+		return
+	}
+	entry := m.entries[m.currEntry]
+	rev := m.revisions[m.currRevision]
+
+	// Revisions are newest first; the newest was replaced by the entry itself
+	newText := m.versionText(entry.Title, entry.Description, entry.Content)
+	newDate := "current version"
+	if m.currRevision > 0 {
+		next := m.revisions[m.currRevision-1]
+		newText = m.versionText(next.Title, next.Description, next.Content)
+		newDate = formatDate(next.Replaced)
+	}
+
+	content := fmt.Sprintf("\nChanges to %q, %d of %d\n", entry.Title, m.currRevision+1, len(m.revisions))
+	content += "From: " + formatDate(rev.Updated) + "\n"
+	content += "Replaced: " + formatDate(rev.Replaced) + " (compared with " + newDate + ")\n\n"
+	content += diffText(m.versionText(rev.Title, rev.Description, rev.Content), newText)
+	m.entry.SetContent(content)
+	m.entry.GotoTop()
+}
+
+// Renders one version of an entry as plain text for diffing
+func (m *model) versionText(title, description, content string) string {
+	return title + "\n\n" + htmlTruncate(description, m.width-4) + "\n\n" + htmlTruncate(content, m.width-4)
+}
+
+// Shows the prompt, reading input of the given kind about f (if any).
+func (m *model) startPrompt(kind promptKind, prompt, value string, f *feed.Feed) tea.Cmd {
+	m.prompting = kind
+	m.promptFeed = f
+	m.prompt.Prompt = prompt
+	m.prompt.SetValue(value)
+	m.prompt.CursorEnd()
+	return m.prompt.Focus()
+}
+
+// Acts on input read with the prompt.
+func (m *model) submitPrompt(kind promptKind, value string) tea.Cmd {
+	value = strings.TrimSpace(value)
+	switch kind {
+	case searchPrompt:
+		m.updateSearchResults(value)
+		m.view = entryListView
+	case addPrompt:
+		if value != "" {
+			return m.discover(value)
+		}
+	case renamePrompt:
+		if value == m.promptFeed.DisplayTitle() {
+			return nil
+		}
+		// Typing the feed's own title resets it
+		if value == m.promptFeed.Title {
+			value = ""
+		}
+		if err := m.store.RenameFeed(m.promptFeed.ID, value); err != nil {
+			log.Println("Failed to rename feed:", err.Error())
+		}
+		m.reloadFeeds(m.promptFeed.ID)
+	case removePrompt:
+		if strings.HasPrefix(strings.ToLower(value), "y") {
+			if err := m.store.Unsubscribe(m.promptFeed.ID); err != nil {
+				log.Println("Failed to unsubscribe:", err.Error())
+			}
+			m.reloadFeeds(0)
+		}
+	}
+	return nil
+}
+
+// Gets the feed selected in feedList, unless it is the Starred pseudo-feed.
+func (m *model) selectedFeed() *feed.Feed {
+	if m.view != feedListView {
+		return nil
+	}
+	if i := m.feedList.GlobalIndex(); i < len(m.feeds) && m.feeds[i].ID != feed.StarredFeedID {
+		return m.feeds[i]
+	}
+	return nil
+}
+
+// Finds the feeds at url in the background. url may be a feed or a web page
+// linking to feeds.
+func (m *model) discover(url string) tea.Cmd {
+	m.activity = "looking for feeds at " + url
+	return func() tea.Msg {
+		feeds, err := feed.Discover(url)
+		return discoveredMsg{url: url, feeds: feeds, err: err}
+	}
+}
+
+// Subscribes to the feed found at url if there is one and lets the user
+// choose otherwise.
+func (m *model) showDiscovered(url string, feeds []*feed.DiscoveredFeed, err error) tea.Cmd {
+	if err != nil {
+		log.Println("Failed to find feeds at", url+":", err.Error())
+		m.status = "No feed found at " + url + ": " + err.Error()
+		return nil
+	}
+
+	if len(feeds) == 1 {
+		return m.subscribe(feeds[0].URL)
+	}
+
+	m.discovered = feeds
+	items := make([]list.Item, len(feeds))
+	for i, f := range feeds {
+		title := f.Title
+		if title == "" {
+			title = f.URL
+		}
+		items[i] = feedItem{title: title, desc: strings.ToUpper(f.Type) + ": " + f.URL, link: f.URL}
+	}
+	m.discoverList.SetItems(items)
+	m.discoverList.Select(0)
+	m.view = discoverView
+	return nil
+}
+
+// Subscribes to url and syncs the new feed in the background.
+func (m *model) subscribe(url string) tea.Cmd {
+	id, err := m.store.Subscribe(url)
+	if err != nil {
+		log.Println("Failed to subscribe:", err.Error())
+		return nil
+	}
+	m.reloadFeeds(id)
+
+	store := m.store
+	return m.startSync("syncing "+url, "Subscribed, but not synced: ", id, func(ctx context.Context) error {
+		return feed.SyncFeed(ctx, store, url)
+	})
+}
+
+// Runs sync in the background, showing activity until it sends a
+// syncDoneMsg. Ctrl-C cancels it.
+func (m *model) startSync(activity, failed string, selected int64, sync func(context.Context) error) tea.Cmd {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.syncGen++
+	gen := m.syncGen
+	m.cancelSync = cancel
+	m.activity = activity + " ([ctrl+c] to cancel)"
+	return func() tea.Msg {
+		return syncDoneMsg{gen: gen, cancel: cancel, err: sync(ctx), failed: failed, selected: selected}
+	}
+}
+
+// Reloads the feeds after a sync, selecting the feed with the given ID (or
+// keeping the selection) and keeping the open feed open. Filtered lists are
+// left alone, since their items can't be replaced.
+func (m *model) reloadSynced(selected int64) {
+	if m.feedList.FilterState() != list.Unfiltered {
+		return
+	}
+
+	if i := m.feedList.GlobalIndex(); selected == 0 && i < len(m.feeds) {
+		selected = m.feeds[i].ID
+	}
+	var open int64
+	if m.currFeed < len(m.feeds) {
+		open = m.feeds[m.currFeed].ID
+	}
+
+	m.reloadFeeds(selected)
+	for i, f := range m.feeds {
+		if f.ID == open {
+			m.currFeed = i
+		}
+	}
+	if m.view == entryListView && !m.showingResults && m.entryList.FilterState() == list.Unfiltered {
+		m.updateEntryList()
+	}
+}
+
+// Pauses or resumes syncing a feed.
+func (m *model) setPaused(f *feed.Feed, paused bool) {
+	if err := m.store.SetPaused(f.ID, paused); err != nil {
+		log.Println("Failed to pause feed:", err.Error())
+		return
+	}
+	f.Paused = paused
+	m.refreshItems()
+}
+
+// Reloads all feeds from the store and selects the feed with the given ID.
+func (m *model) reloadFeeds(selected int64) {
+	m.feeds = m.withStarred(feed.GetFeeds(m.store))
+	m.updateFeedList()
+	for i, f := range m.feeds {
+		if f.ID == selected {
+			m.feedList.Select(i)
+		}
+	}
+}
+
+// In entryList, shows the entries of all feeds matching query.
+func (m *model) updateSearchResults(query string) {
+	results, err := m.store.Search(query)
+	if err != nil {
+		log.Println("Search failed:", err.Error())
+	}
+
+	entryItems := []list.Item{}
+	m.entries = nil
+	for _, result := range results {
+		m.entries = append(m.entries, result.Entry)
+		entryItems = append(entryItems, feedItem{
+			title: entryTitle(result.Entry),
+			desc:  result.FeedTitle + ": " + result.Snippet,
+			link:  result.Entry.URL,
+		})
+	}
+	m.entryList.Title = fmt.Sprintf("Search: %s (%d results)", query, len(results))
+	m.showingResults = true
+	m.entryList.SetItems(entryItems)
+	m.entryList.SetDelegate(listDelegate)
+	m.entryList.Select(0)
+	m.currEntry = 0
+}
+
+// Stars or unstars an entry, and reloads the Starred pseudo-feed.
+func (m *model) toggleStarred(entry *feed.Entry) {
+	starred := !entry.Starred
+	if err := m.store.SetStarred(entry.ID, starred); err != nil {
+		log.Println("Failed to star entry:", err.Error())
+		return
+	}
+
+	m.updateEntry(entry.ID, func(e *feed.Entry) { e.Starred = starred })
+	m.feeds[0] = feed.GetStarredFeed(m.store)
+	m.refreshItems()
+}
+
+// Marks an entry read or unread.
+func (m *model) setRead(entry *feed.Entry, read bool) {
+	if err := m.store.SetRead(entry.ID, read); err != nil {
+		log.Println("Failed to mark entry read:", err.Error())
+		return
+	}
+
+	m.updateEntry(entry.ID, func(e *feed.Entry) { e.Read = read })
+	m.refreshItems()
+}
+
+// Marks all entries of a feed read.
+func (m *model) markFeedRead(f *feed.Feed) {
+	if f.ID == feed.StarredFeedID {
+		m.markEntriesRead(f.Entries)
+		return
+	}
+
+	if err := m.store.MarkFeedRead(f.ID); err != nil {
+		log.Println("Failed to mark feed read:", err.Error())
+		return
+	}
+	for _, entry := range f.Entries {
+		m.updateEntry(entry.ID, func(e *feed.Entry) { e.Read = true })
+	}
+	m.refreshItems()
+}
+
+// Marks each of entries read.
+func (m *model) markEntriesRead(entries []*feed.Entry) {
+	for _, entry := range entries {
+		if entry.Read {
+			continue
+		}
+		if err := m.store.SetRead(entry.ID, true); err != nil {
+			log.Println("Failed to mark entry read:", err.Error())
+			break
+		}
+		m.updateEntry(entry.ID, func(e *feed.Entry) { e.Read = true })
+	}
+	m.refreshItems()
+}
+
+// Applies fn to every loaded copy of the entry with the given ID.
+// The Starred pseudo-feed and search results hold their own copies of entries.
+func (m *model) updateEntry(id int64, fn func(*feed.Entry)) {
+	for _, f := range m.feeds {
+		for _, e := range f.Entries {
+			if e.ID == id {
+				fn(e)
+			}
+		}
+	}
+	for _, e := range m.entries {
+		if e.ID == id {
+			fn(e)
+		}
+	}
+}
+
+// Refreshes the titles in the feed and entry lists after read or starred
+// state changes.
+func (m *model) refreshItems() {
+	for i, f := range m.feeds {
+		if i < len(m.feedList.Items()) {
+			m.feedList.SetItem(i, newFeedItem(f))
+		}
+	}
+	items := m.entryList.Items()
+	for i, entry := range m.entries {
+		if i >= len(items) {
+			break
+		}
+		if item, ok := items[i].(feedItem); ok {
+			item.title = entryTitle(entry)
+			m.entryList.SetItem(i, item)
+		}
+	}
+}
+
+// Creates a feed list item, showing the number of unread entries and whether
+// the feed is paused
+func newFeedItem(f *feed.Feed) feedItem {
+	title := f.DisplayTitle()
+	if unread := f.UnreadCount(); unread > 0 {
+		title += fmt.Sprintf(" (%d)", unread)
+	}
+	if f.Paused {
+		title += " [paused]"
+	}
+	if f.Gone {
+		title += " [gone]"
+	}
+
+	// Flag feeds whose last sync failed
+	desc := f.Description
+	if f.Failures > 0 {
+		title = "! " + title
+		desc = "Error: " + f.LastError
+	}
+	return feedItem{title: title, desc: desc, link: f.URL}
+}
+
+// Gets the list title of an entry, flagging unread (N), starred (*) and
+// edited (~) entries
+func entryTitle(entry *feed.Entry) string {
+	flags := []byte("    ")
+	if !entry.Read {
+		flags[0] = 'N'
+	}
+	if entry.Starred {
+		flags[1] = '*'
+	}
+	if entry.Revisions > 0 {
+		flags[2] = '~'
+	}
+	return string(flags) + entry.Title
+}
+
+// Formats a date in local time, or "unknown" for the zero time
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Local().Format("Mon, 02 Jan 2006 15:04 MST")
+}
+
+// Formats a list of authors as "Name <email>, Name"
+func formatAuthors(authors []feed.Person) string {
+	names := make([]string, len(authors))
+	for i, author := range authors {
+		switch {
+		case author.Name == "":
+			names[i] = author.Email
+		case author.Email == "":
+			names[i] = author.Name
+		default:
+			names[i] = author.Name + " <" + author.Email + ">"
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
 func (m *model) updateFeedList() {
 	feedItems := []list.Item{}
 	for _, f := range m.feeds {
-		feedItems = append(feedItems, feedItem{
-			title: f.Title,
-			desc:  f.Description,
-			link:  f.URL,
-		})
+		feedItems = append(feedItems, newFeedItem(f))
 	}
 	m.feedList.SetItems(feedItems)
 	m.feedList.SetDelegate(listDelegate)